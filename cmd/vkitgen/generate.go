@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"sort"
+	"text/template"
+)
+
+type genFile struct {
+	Package  string
+	Service  string
+	Imports  map[string]string
+	Services []*genService
+}
+
+type genService struct {
+	Name    string
+	Methods []*genMethod
+}
+
+type genMethod struct {
+	Name string
+	// Struct.Method
+	FullName     string
+	ReqType      string
+	RespType     string
+	Url          string
+	ClientStream bool
+	ServerStream bool
+}
+
+func (m *genMethod) Stream() bool {
+	return m.ClientStream || m.ServerStream || m.ReqType == ""
+}
+
+type genImport struct {
+	Name string
+	Path string
+}
+
+func (f *genFile) SortedImports() []genImport {
+	list := make([]genImport, 0, len(f.Imports))
+	for name, path := range f.Imports {
+		list = append(list, genImport{Name: name, Path: path})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by vkitgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/vison888/go-vkit/grpcclient"
+	"github.com/vison888/go-vkit/grpcx"
+	"google.golang.org/grpc"
+{{- range .SortedImports}}
+	{{.Name}} "{{.Path}}"
+{{- end}}
+)
+
+{{range $s := .Services}}
+// {{$s.Name}}ApiEndpoints {{$s.Name}}的接口表, 用于RegisterApiEndpoint
+var {{$s.Name}}ApiEndpoints = []*grpcx.ApiEndpoint{
+{{- range .Methods}}
+	{
+		Method:       "{{.FullName}}",
+		Url:          "{{.Url}}",
+		ClientStream: {{.ClientStream}},
+		ServerStream: {{.ServerStream}},
+	},
+{{- end}}
+}
+
+// {{$s.Name}}Client {{$s.Name}}的类型化客户端
+type {{$s.Name}}Client struct {
+	service string
+	addr    string
+	opts    []grpcclient.Option
+}
+
+func New{{$s.Name}}Client(addr string, opts ...grpcclient.Option) *{{$s.Name}}Client {
+	return &{{$s.Name}}Client{
+		service: "{{$.Service}}",
+		addr:    addr,
+		opts:    opts,
+	}
+}
+{{range .Methods}}
+{{- if .Stream}}
+func (c *{{$s.Name}}Client) {{.Name}}(ctx context.Context, opts ...grpc.CallOption) (*grpcclient.TypedStream[{{if .ReqType}}{{.ReqType}}{{else}}struct{}{{end}}, {{.RespType}}], error) {
+	desc := &grpc.StreamDesc{
+		StreamName:    "{{.Name}}",
+		ClientStreams: {{.ClientStream}},
+		ServerStreams: {{.ServerStream}},
+	}
+	stream, err := grpcclient.GetConnClient(c.addr, c.opts...).NewStream(ctx, desc, c.service, "{{.FullName}}", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return grpcclient.NewTypedStream[{{if .ReqType}}{{.ReqType}}{{else}}struct{}{{end}}, {{.RespType}}](stream), nil
+}
+{{else}}
+func (c *{{$s.Name}}Client) {{.Name}}(ctx context.Context, req *{{.ReqType}}, opts ...grpc.CallOption) (*{{.RespType}}, error) {
+	resp := &{{.RespType}}{}
+	err := grpcclient.GetConnClient(c.addr, c.opts...).Invoke(ctx, c.service, "{{.FullName}}", req, resp, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+{{end}}
+{{- end}}
+{{- end}}
+`))
+
+func generate(f *genFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
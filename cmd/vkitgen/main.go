@@ -0,0 +1,99 @@
+// vkitgen 根据业务handler或proto文件生成类型化的grpc客户端及ApiEndpoint表
+//
+// 用法:
+//
+//	//go:generate go run github.com/vison888/go-vkit/cmd/vkitgen -service sso
+//	//go:generate go run github.com/vison888/go-vkit/cmd/vkitgen -service sso -proto auth.proto
+//
+// 未指定-proto时解析当前目录的go源码, 方法签名需为
+// func (*T) M(ctx context.Context, req *Req, resp *Resp) error,
+// 若源码中有[]*grpcx.ApiEndpoint表则只生成表中的方法, 并沿用其Url及流类型
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	var (
+		dir     = flag.String("dir", ".", "go源码目录")
+		proto   = flag.String("proto", "", "proto文件, 指定时按proto生成")
+		service = flag.String("service", "", "服务名, 即Invoke的service参数, 缺省为proto的package")
+		prefix  = flag.String("prefix", "/rpc", "接口url前缀")
+		types   = flag.String("types", "", "只生成指定的结构体, 逗号分隔")
+		output  = flag.String("o", "vkit_client.gen.go", "输出文件")
+	)
+	flag.Parse()
+
+	if err := run(*dir, *proto, *service, *prefix, *types, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "vkitgen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, proto, service, prefix, types, output string) error {
+	var (
+		f   *genFile
+		err error
+	)
+	if proto != "" {
+		f, err = parseProtoFile(proto)
+		output = filepath.Join(filepath.Dir(proto), output)
+	} else {
+		f, err = parseGoDir(dir, filepath.Base(output))
+		output = filepath.Join(dir, output)
+	}
+	if err != nil {
+		return err
+	}
+
+	if service != "" {
+		f.Service = service
+	}
+	if f.Service == "" {
+		return fmt.Errorf("service is empty")
+	}
+	f.filter(types)
+	if len(f.Services) == 0 {
+		return fmt.Errorf("no service found")
+	}
+	f.fillUrl(prefix)
+
+	b, err := generate(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, b, 0644)
+}
+
+func (f *genFile) filter(types string) {
+	if types == "" {
+		return
+	}
+	want := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		want[strings.TrimSpace(t)] = true
+	}
+	list := f.Services[:0]
+	for _, s := range f.Services {
+		if want[s.Name] {
+			list = append(list, s)
+		}
+	}
+	f.Services = list
+}
+
+func (f *genFile) fillUrl(prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	for _, s := range f.Services {
+		for _, m := range s.Methods {
+			if m.Url == "" {
+				m.Url = fmt.Sprintf("%s/%s/%s", prefix, f.Service, m.FullName)
+			}
+		}
+	}
+}
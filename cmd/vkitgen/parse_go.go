@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseGoDir 解析目录下的业务handler
+func parseGoDir(dir string, skipFile string) (*genFile, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && name != skipFile
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect one package in %s, got %d", dir, len(pkgs))
+	}
+
+	var files []*ast.File
+	var pkgName string
+	for name, pkg := range pkgs {
+		pkgName = name
+		for _, file := range pkg.Files {
+			files = append(files, file)
+		}
+	}
+	return parseGoFiles(pkgName, files)
+}
+
+func parseGoFiles(pkgName string, files []*ast.File) (*genFile, error) {
+	f := &genFile{
+		Package: pkgName,
+		Imports: make(map[string]string),
+	}
+
+	endpoints := make(map[string]*genMethod)
+	for _, file := range files {
+		collectApiEndpoints(file, endpoints)
+	}
+
+	services := make(map[string]*genService)
+	for _, file := range files {
+		imports := fileImports(file)
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || !fn.Name.IsExported() {
+				continue
+			}
+			recv := receiverName(fn.Recv)
+			if recv == "" || !ast.IsExported(recv) {
+				continue
+			}
+
+			m, pkgs, ok := handlerMethod(recv, fn)
+			if !ok {
+				continue
+			}
+			if len(endpoints) > 0 {
+				ep, ok := endpoints[m.FullName]
+				if !ok {
+					continue
+				}
+				m.Url = ep.Url
+				m.ClientStream = ep.ClientStream
+				m.ServerStream = ep.ServerStream
+			}
+			for _, p := range pkgs {
+				path, ok := imports[p]
+				if !ok {
+					return nil, fmt.Errorf("import %s not found for %s", p, m.FullName)
+				}
+				f.Imports[p] = path
+			}
+
+			s, ok := services[recv]
+			if !ok {
+				s = &genService{Name: recv}
+				services[recv] = s
+			}
+			s.Methods = append(s.Methods, m)
+		}
+	}
+
+	for _, s := range services {
+		sort.Slice(s.Methods, func(i, j int) bool {
+			return s.Methods[i].Name < s.Methods[j].Name
+		})
+		f.Services = append(f.Services, s)
+	}
+	sort.Slice(f.Services, func(i, j int) bool {
+		return f.Services[i].Name < f.Services[j].Name
+	})
+	return f, nil
+}
+
+func receiverName(recv *ast.FieldList) string {
+	if len(recv.List) != 1 {
+		return ""
+	}
+	star, ok := recv.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	ident, ok := star.X.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	return ident.Name
+}
+
+// handlerMethod 匹配(ctx context.Context, req *Req, resp *Resp) error或(ctx context.Context, resp *Resp) error
+func handlerMethod(recv string, fn *ast.FuncDecl) (*genMethod, []string, bool) {
+	results := fn.Type.Results
+	if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return nil, nil, false
+	}
+	if ident, ok := results.List[0].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return nil, nil, false
+	}
+
+	var params []ast.Expr
+	for _, field := range fn.Type.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	if len(params) != 2 && len(params) != 3 {
+		return nil, nil, false
+	}
+	if sel, ok := params[0].(*ast.SelectorExpr); !ok || exprString(sel) != "context.Context" {
+		return nil, nil, false
+	}
+
+	var pkgs []string
+	var types []string
+	for _, p := range params[1:] {
+		star, ok := p.(*ast.StarExpr)
+		if !ok {
+			return nil, nil, false
+		}
+		switch t := star.X.(type) {
+		case *ast.Ident:
+			types = append(types, t.Name)
+		case *ast.SelectorExpr:
+			pkg, ok := t.X.(*ast.Ident)
+			if !ok {
+				return nil, nil, false
+			}
+			pkgs = append(pkgs, pkg.Name)
+			types = append(types, exprString(t))
+		default:
+			return nil, nil, false
+		}
+	}
+
+	m := &genMethod{
+		Name:     fn.Name.Name,
+		FullName: recv + "." + fn.Name.Name,
+		RespType: types[len(types)-1],
+	}
+	if len(types) == 2 {
+		m.ReqType = types[0]
+	}
+	return m, pkgs, true
+}
+
+func exprString(sel *ast.SelectorExpr) string {
+	if ident, ok := sel.X.(*ast.Ident); ok {
+		return ident.Name + "." + sel.Sel.Name
+	}
+	return sel.Sel.Name
+}
+
+func fileImports(file *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// collectApiEndpoints 收集源码中的grpcx.ApiEndpoint字面量
+func collectApiEndpoints(file *ast.File, endpoints map[string]*genMethod) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		if isApiEndpointType(lit.Type) {
+			addApiEndpoint(lit, endpoints)
+			return false
+		}
+		arr, ok := lit.Type.(*ast.ArrayType)
+		if !ok || !isApiEndpointType(arr.Elt) {
+			return true
+		}
+		for _, elt := range lit.Elts {
+			if u, ok := elt.(*ast.UnaryExpr); ok {
+				elt = u.X
+			}
+			if c, ok := elt.(*ast.CompositeLit); ok {
+				addApiEndpoint(c, endpoints)
+			}
+		}
+		return false
+	})
+}
+
+func isApiEndpointType(expr ast.Expr) bool {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "ApiEndpoint"
+}
+
+func addApiEndpoint(lit *ast.CompositeLit, endpoints map[string]*genMethod) {
+	ep := &genMethod{}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		switch v := kv.Value.(type) {
+		case *ast.BasicLit:
+			s, err := strconv.Unquote(v.Value)
+			if err != nil {
+				continue
+			}
+			switch key.Name {
+			case "Method":
+				ep.FullName = s
+			case "Url":
+				ep.Url = s
+			}
+		case *ast.Ident:
+			switch key.Name {
+			case "ClientStream":
+				ep.ClientStream = v.Name == "true"
+			case "ServerStream":
+				ep.ServerStream = v.Name == "true"
+			}
+		}
+	}
+	if ep.FullName != "" {
+		endpoints[ep.FullName] = ep
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	protoCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	protoPackageRe = regexp.MustCompile(`\bpackage\s+([\w.]+)\s*;`)
+	protoGoPkgRe   = regexp.MustCompile(`option\s+go_package\s*=\s*"([^"]+)"\s*;`)
+	protoServiceRe = regexp.MustCompile(`\bservice\s+(\w+)\s*\{`)
+	protoRpcRe     = regexp.MustCompile(`\brpc\s+(\w+)\s*\(\s*(stream\s+)?([\w.]+)\s*\)\s*returns\s*\(\s*(stream\s+)?([\w.]+)\s*\)`)
+)
+
+func parseProtoFile(path string) (*genFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseProto(string(b))
+}
+
+// parseProto 解析proto中的service定义, 消息类型需与生成代码在同一个go包
+func parseProto(src string) (*genFile, error) {
+	src = protoCommentRe.ReplaceAllString(src, "")
+
+	f := &genFile{Imports: make(map[string]string)}
+	var protoPkg string
+	if m := protoPackageRe.FindStringSubmatch(src); m != nil {
+		protoPkg = m[1]
+		f.Service = protoPkg[strings.LastIndex(protoPkg, ".")+1:]
+	}
+	f.Package = f.Service
+	if m := protoGoPkgRe.FindStringSubmatch(src); m != nil {
+		goPkg := m[1]
+		if i := strings.Index(goPkg, ";"); i != -1 {
+			f.Package = goPkg[i+1:]
+		} else {
+			f.Package = goPkg[strings.LastIndex(goPkg, "/")+1:]
+		}
+	}
+	if f.Package == "" {
+		return nil, fmt.Errorf("go package not found")
+	}
+
+	for _, loc := range protoServiceRe.FindAllStringSubmatchIndex(src, -1) {
+		name := src[loc[2]:loc[3]]
+		body, err := braceBody(src, loc[1]-1)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s", name, err)
+		}
+
+		s := &genService{Name: name}
+		for _, m := range protoRpcRe.FindAllStringSubmatch(body, -1) {
+			reqType, err := protoGoType(protoPkg, m[3])
+			if err != nil {
+				return nil, err
+			}
+			respType, err := protoGoType(protoPkg, m[5])
+			if err != nil {
+				return nil, err
+			}
+			s.Methods = append(s.Methods, &genMethod{
+				Name:         m[1],
+				FullName:     name + "." + m[1],
+				ReqType:      reqType,
+				RespType:     respType,
+				ClientStream: m[2] != "",
+				ServerStream: m[4] != "",
+			})
+		}
+		if len(s.Methods) > 0 {
+			f.Services = append(f.Services, s)
+		}
+	}
+	return f, nil
+}
+
+// braceBody 返回start处左括号对应的内容
+func braceBody(src string, start int) (string, error) {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return src[start+1 : i], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unbalanced braces")
+}
+
+// protoGoType 消息名转go类型名, 嵌套消息Outer.Inner转为Outer_Inner
+func protoGoType(protoPkg, name string) (string, error) {
+	name = strings.TrimPrefix(name, ".")
+	if protoPkg != "" && strings.HasPrefix(name, protoPkg+".") {
+		name = strings.TrimPrefix(name, protoPkg+".")
+	}
+	parts := strings.Split(name, ".")
+	for _, p := range parts {
+		if p == "" || p[0] < 'A' || p[0] > 'Z' {
+			return "", fmt.Errorf("message %s not in package %s", name, protoPkg)
+		}
+	}
+	return strings.Join(parts, "_"), nil
+}
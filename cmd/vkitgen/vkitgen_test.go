@@ -0,0 +1,113 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const testGoSrc = `package sso
+
+import (
+	"context"
+
+	"github.com/vison888/go-vkit/grpcx"
+	pb "example.com/sso/proto"
+)
+
+var endpoints = []*grpcx.ApiEndpoint{
+	{Method: "UserService.Login", Url: "/api/login"},
+	{Method: "UserService.Watch", ServerStream: true},
+}
+
+type UserService struct{}
+
+func (s *UserService) Login(ctx context.Context, req *pb.LoginReq, resp *pb.LoginResp) error {
+	return nil
+}
+
+func (s *UserService) Watch(ctx context.Context, resp *WatchResp) error {
+	return nil
+}
+
+func (s *UserService) Hidden(ctx context.Context, req *pb.LoginReq, resp *pb.LoginResp) error {
+	return nil
+}
+
+type WatchResp struct{}
+`
+
+func TestParseGo(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "sso.go", testGoSrc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseGoFiles("sso", []*ast.File{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Service = "sso"
+	f.fillUrl("/rpc")
+
+	if len(f.Services) != 1 || len(f.Services[0].Methods) != 2 {
+		t.Fatalf("unexpected services %+v", f.Services)
+	}
+	login := f.Services[0].Methods[0]
+	if login.Url != "/api/login" || login.ReqType != "pb.LoginReq" || login.Stream() {
+		t.Fatalf("unexpected login %+v", login)
+	}
+	watch := f.Services[0].Methods[1]
+	if watch.Url != "/rpc/sso/UserService.Watch" || !watch.ServerStream || !watch.Stream() {
+		t.Fatalf("unexpected watch %+v", watch)
+	}
+
+	b, err := generate(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`pb "example.com/sso/proto"`,
+		"func NewUserServiceClient(",
+		"*grpcclient.TypedStream[struct{}, WatchResp]",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("generated code missing %q\n%s", want, b)
+		}
+	}
+}
+
+const testProtoSrc = `syntax = "proto3";
+package vkit.sso;
+option go_package = "example.com/sso/proto;ssopb";
+
+// service Fake {}
+service Auth {
+	rpc Login (LoginReq) returns (LoginResp);
+	rpc Chat (stream Msg) returns (stream vkit.sso.Msg.Reply) {}
+}
+`
+
+func TestParseProto(t *testing.T) {
+	f, err := parseProto(testProtoSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Package != "ssopb" || f.Service != "sso" {
+		t.Fatalf("unexpected file %+v", f)
+	}
+	if len(f.Services) != 1 || len(f.Services[0].Methods) != 2 {
+		t.Fatalf("unexpected services %+v", f.Services)
+	}
+	chat := f.Services[0].Methods[1]
+	if !chat.ClientStream || !chat.ServerStream || chat.RespType != "Msg_Reply" {
+		t.Fatalf("unexpected chat %+v", chat)
+	}
+
+	f.fillUrl("/rpc")
+	if _, err := generate(f); err != nil {
+		t.Fatal(err)
+	}
+}
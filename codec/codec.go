@@ -17,6 +17,11 @@ type JsonCodec struct{}
 type ProtoCodec struct{}
 type WrapCodec struct{ encoding.Codec }
 
+// Frame 已编码的消息, 编解码时原样透传
+type Frame struct {
+	Data []byte
+}
+
 var jsonpbMarshaler = &protojson.MarshalOptions{
 	//UseEnumNumbers: true,
 	UseProtoNames:   true,
@@ -41,6 +46,10 @@ var (
 )
 
 func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	if f, ok := v.(*Frame); ok {
+		return f.Data, nil
+	}
+
 	m, ok := v.(proto.Message)
 	if !ok {
 		return nil, ErrInvalidMessage
@@ -49,6 +58,11 @@ func (ProtoCodec) Marshal(v any) ([]byte, error) {
 }
 
 func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	if f, ok := v.(*Frame); ok {
+		f.Data = append([]byte(nil), data...)
+		return nil
+	}
+
 	m, ok := v.(proto.Message)
 	if !ok {
 		return ErrInvalidMessage
@@ -62,6 +76,10 @@ func (ProtoCodec) Name() string {
 }
 
 func (JsonCodec) Marshal(v any) ([]byte, error) {
+	if f, ok := v.(*Frame); ok {
+		return f.Data, nil
+	}
+
 	if pb, ok := v.(proto.Message); ok {
 		s, err := jsonpbMarshaler.Marshal(pb)
 		return s, err
@@ -75,6 +93,10 @@ func (JsonCodec) Marshal(v any) ([]byte, error) {
 }
 
 func (JsonCodec) Unmarshal(data []byte, v any) error {
+	if f, ok := v.(*Frame); ok {
+		f.Data = append([]byte(nil), data...)
+		return nil
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -3,21 +3,31 @@ package errorsx
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
 )
 
 var (
-	OK         = NewErrno(0, 0, "OK")
-	FAIL       = NewErrno(0, -1, "未知错误")
-	PARAM_ERR  = NewErrno(0, -2, "参数错误")
-	SYSTEM_ERR = NewErrno(0, -3, "系统异常")
+	OK         = NewErrno(0, 0, "OK").Translate("en", "OK")
+	FAIL       = NewErrno(0, -1, "未知错误").Translate("en", "Unknown error")
+	PARAM_ERR  = NewErrno(0, -2, "参数错误").Translate("en", "Invalid parameter")
+	SYSTEM_ERR = NewErrno(0, -3, "系统异常").Translate("en", "System error")
 )
 
 type Errno struct {
 	Project int32  `json:"project"`
 	Code    int32  `json:"code"`
 	Msg     string `json:"msg"`
+	// 补充说明
+	Detail     string                      `json:"detail,omitempty"`
+	Violations []*neterrors.FieldViolation `json:"violations,omitempty"`
+
+	// 原始错误及调用栈只用于内部日志
+	cause error
+	stack neterrors.Stack
 }
 
+// NewErrno 创建并登记错误码, 错误码重复时panic
 func NewErrno(project int32, code int32, msg string) *Errno {
 	if project > 1000 {
 		panic("project invalid, should be <= 1000")
@@ -26,6 +36,7 @@ func NewErrno(project int32, code int32, msg string) *Errno {
 		panic("code invalid, should be 1~999")
 	}
 	err := &Errno{Project: project, Code: project*1000 + code, Msg: msg}
+	registry.register(err)
 	return err
 }
 
@@ -34,6 +45,55 @@ func (e *Errno) Fail(format string, v ...any) *Errno {
 	return ret
 }
 
+// Wrap 以该错误码包装原始错误, CaptureStack开启时记录调用栈
+func (e *Errno) Wrap(err error, format string, v ...any) *Errno {
+	ret := &Errno{Project: e.Project, Code: e.Code, Msg: fmt.Sprintf(format, v...), cause: err}
+	if neterrors.CaptureStack {
+		ret.stack = neterrors.Callers(1)
+	}
+	return ret
+}
+
+func (e *Errno) WithDetail(detail string) *Errno {
+	c := *e
+	c.Detail = detail
+	return &c
+}
+
+func (e *Errno) WithViolations(violations ...*neterrors.FieldViolation) *Errno {
+	c := *e
+	c.Violations = append(append([]*neterrors.FieldViolation{}, e.Violations...), violations...)
+	return &c
+}
+
+func (e *Errno) WithStack() *Errno {
+	c := *e
+	c.stack = neterrors.Callers(1)
+	return &c
+}
+
+func (e *Errno) Unwrap() error {
+	return e.cause
+}
+
+// Is 错误码相同即视为同一错误, 如errors.Is(err, PARAM_ERR)
+func (e *Errno) Is(target error) bool {
+	t, ok := target.(*Errno)
+	return ok && t.Code == e.Code
+}
+
+func (e *Errno) Stack() string {
+	return e.stack.String()
+}
+
+// ToNetError 转为业务错误返回给客户端
+func (e *Errno) ToNetError() *neterrors.NetError {
+	ne := neterrors.BusinessError(e.Code, e.Msg).(*neterrors.NetError)
+	ne.Detail = e.Detail
+	ne.Violations = e.Violations
+	return ne.WithCause(e)
+}
+
 func (e *Errno) Error() string {
 	b, _ := json.Marshal(e)
 	return string(b)
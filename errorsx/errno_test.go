@@ -1,6 +1,11 @@
 package errorsx
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
 
 func TestProjectMax(t *testing.T) {
 	err := NewErrno(1, 1, "example")
@@ -13,3 +18,54 @@ func TestProjectMax(t *testing.T) {
 	}
 
 }
+
+func TestErrnoWrap(t *testing.T) {
+	cause := errors.New("db closed")
+	err := error(PARAM_ERR.Wrap(cause, "name invalid").WithDetail("name is empty"))
+	if !errors.Is(err, PARAM_ERR) || errors.Is(err, SYSTEM_ERR) {
+		t.Fatalf("errors.Is fail %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("cause should be unwrapped %v", err)
+	}
+
+	var verr *Errno
+	if !errors.As(fmt.Errorf("handler: %w", err), &verr) {
+		t.Fatalf("errors.As fail %v", err)
+	}
+	ne := verr.ToNetError()
+	if ne.Code != PARAM_ERR.Code || ne.Status != 200 || ne.Detail != "name is empty" || !errors.Is(ne, cause) {
+		t.Fatalf("invalid conversation %v", ne)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate code should panic")
+		}
+	}()
+
+	e := NewErrno(2, 1, "余额不足").Translate("en", "Insufficient balance")
+	if msg := Localize(e.Code, e.Msg, "fr;q=0.5,en-US,zh;q=0.8"); msg != "Insufficient balance" {
+		t.Fatalf("invalid localize %s", msg)
+	}
+	if msg := Localize(e.Code, e.Msg, "zh-CN,en;q=0.8"); msg != "余额不足" {
+		t.Fatalf("default lang should not be translated %s", msg)
+	}
+	if msg := Localize(e.Code, "余额不足100", "en"); msg != "余额不足100" {
+		t.Fatalf("custom msg should not be translated %s", msg)
+	}
+	if err := LoadMessages("ja", map[int32]string{e.Code: "残高不足", 999999: "x"}); err == nil {
+		t.Fatal("unregistered code should fail")
+	}
+
+	if md := CatalogMarkdown(); !strings.Contains(md, "| 2 | 2001 | 余额不足 | Insufficient balance |") {
+		t.Fatalf("invalid markdown %s", md)
+	}
+	if b, err := CatalogJSON(); err != nil || !strings.Contains(string(b), `"code": 2001`) {
+		t.Fatalf("invalid json %s %v", b, err)
+	}
+
+	NewErrno(2, 1, "重复")
+}
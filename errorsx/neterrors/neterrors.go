@@ -2,15 +2,39 @@ package neterrors
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/vison888/go-vkit/logger"
+	"google.golang.org/grpc/status"
 )
 
 type NetError struct {
 	Code   int32  `json:"code"`
 	Status int32  `json:"status"`
 	Msg    string `json:"msg"`
+	// 补充说明
+	Detail string `json:"detail,omitempty"`
+	// 参数校验失败的字段
+	Violations []*FieldViolation `json:"violations,omitempty"`
+	// 建议的重试间隔
+	Retry *RetryInfo `json:"retry,omitempty"`
+
+	// 原始错误及调用栈只用于内部日志, 不返回给客户端
+	cause error
+	stack Stack
+}
+
+// FieldViolation 参数校验失败的字段
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// RetryInfo 客户端应在DelayMs毫秒后重试
+type RetryInfo struct {
+	DelayMs int64 `json:"delayMs"`
 }
 
 func (e *NetError) Error() string {
@@ -18,31 +42,124 @@ func (e *NetError) Error() string {
 	return string(b)
 }
 
+// Unwrap 支持errors.Is/errors.As查找原始错误
+func (e *NetError) Unwrap() error {
+	return e.cause
+}
+
+// Is 业务错误码相同即视为同一错误
+// 通用错误码-1(如BadRequest、NotFound)不按错误码比较, 只与同一实例相等
+func (e *NetError) Is(target error) bool {
+	t, ok := target.(*NetError)
+	return ok && e.Code != -1 && t.Code == e.Code && t.Status == e.Status
+}
+
+// Stack 返回WithStack或Wrap时记录的调用栈
+func (e *NetError) Stack() string {
+	return e.stack.String()
+}
+
+func (e *NetError) clone() *NetError {
+	c := *e
+	return &c
+}
+
+func (e *NetError) WithDetail(detail string) *NetError {
+	c := e.clone()
+	c.Detail = detail
+	return c
+}
+
+func (e *NetError) WithViolations(violations ...*FieldViolation) *NetError {
+	c := e.clone()
+	c.Violations = append(append([]*FieldViolation{}, e.Violations...), violations...)
+	return c
+}
+
+func (e *NetError) WithRetry(delay time.Duration) *NetError {
+	c := e.clone()
+	c.Retry = &RetryInfo{DelayMs: delay.Milliseconds()}
+	return c
+}
+
+func (e *NetError) WithCause(cause error) *NetError {
+	c := e.clone()
+	c.cause = cause
+	return c
+}
+
+// WithStack 记录当前调用栈
+func (e *NetError) WithStack() *NetError {
+	c := e.clone()
+	c.stack = Callers(1)
+	return c
+}
+
 func New(msg, detail string, code int32, status int32) error {
 	return &NetError{
 		Msg:    msg,
+		Detail: detail,
 		Code:   code,
 		Status: status,
 	}
 }
 
+// Wrap 包装原始错误, 沿用其中NetError或Errno的错误码, 否则为500
+// CaptureStack开启时记录调用栈
+func Wrap(err error, format string, a ...any) error {
+	if err == nil {
+		return nil
+	}
+	e := &NetError{
+		Code:   -1,
+		Status: 500,
+		Msg:    fmt.Sprintf(format, a...),
+		cause:  err,
+	}
+	var verr *NetError
+	var conv interface{ ToNetError() *NetError }
+	if errors.As(err, &verr) {
+		e.Code, e.Status = verr.Code, verr.Status
+	} else if errors.As(err, &conv) {
+		ne := conv.ToNetError()
+		e.Code, e.Status = ne.Code, ne.Status
+	}
+	if CaptureStack {
+		e.stack = Callers(1)
+	}
+	return e
+}
+
 func FromError(err error) *NetError {
 	if err == nil {
 		return nil
 	}
-	if verr, ok := err.(*NetError); ok && verr != nil {
+	var verr *NetError
+	if errors.As(err, &verr) && verr != nil {
 		return verr
 	}
+	var conv interface{ ToNetError() *NetError }
+	if errors.As(err, &conv) {
+		return conv.ToNetError()
+	}
+	if st, ok := status.FromError(err); ok {
+		return FromStatus(st)
+	}
 
 	return Parse(err.Error())
 }
 
+// Parse 解析json格式的错误, 失败时原文作为Msg
 func Parse(err string) *NetError {
 	e := new(NetError)
 	errr := json.Unmarshal([]byte(err), e)
 	if errr != nil {
-		logger.Infof("parse fail %s", e)
-		e.Msg = e.Error()
+		logger.Infof("parse fail %s", err)
+		return &NetError{
+			Code:   -1,
+			Status: 500,
+			Msg:    err,
+		}
 	}
 	return e
 }
@@ -2,7 +2,12 @@ package neterrors
 
 import (
 	er "errors"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestFromError(t *testing.T) {
@@ -18,3 +23,71 @@ func TestFromError(t *testing.T) {
 	}
 
 }
+
+func TestGRPCStatus(t *testing.T) {
+	err := BusinessError(1001, "余额不足")
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unknown || st.Message() != "余额不足" {
+		t.Fatalf("invalid status %v", st)
+	}
+	merr := FromError(st.Err())
+	if merr.Code != 1001 || merr.Status != 200 || merr.Msg != "余额不足" {
+		t.Fatalf("invalid conversation %v", merr)
+	}
+
+	if code := status.Code(NotFound("not found")); code != codes.NotFound {
+		t.Fatalf("invalid code %v", code)
+	}
+
+	// 非vkit服务的错误
+	merr = FromError(status.Error(codes.PermissionDenied, "denied"))
+	if merr.Code != -1 || merr.Status != 403 || merr.Msg != "denied" {
+		t.Fatalf("invalid conversation %v", merr)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cause := er.New("dial fail")
+	notFound := NotFound("user").(*NetError).WithCause(cause)
+	err := Wrap(notFound, "load user")
+	if !er.Is(err, cause) || !er.Is(err, notFound) {
+		t.Fatalf("errors.Is fail %v", err)
+	}
+	// 通用错误码只与同一实例相等, 业务错误码相同即相等
+	if er.Is(BadRequest("name"), BadRequest("age")) || er.Is(err, NotFound("order")) {
+		t.Fatal("generic errors should not match each other")
+	}
+	if !er.Is(Wrap(BusinessError(1001, "a"), "load"), BusinessError(1001, "b")) {
+		t.Fatal("business errors with same code should match")
+	}
+	if merr := FromError(err); merr.Status != 404 || merr.Msg != "load user" {
+		t.Fatalf("invalid conversation %v", merr)
+	}
+	if merr := FromError(Wrap(cause, "load")); merr.Status != 500 || merr.Code != -1 {
+		t.Fatalf("invalid conversation %v", merr)
+	}
+
+	CaptureStack = true
+	defer func() { CaptureStack = false }()
+	err = Wrap(cause, "load")
+	if !strings.Contains(StackOf(err), "TestWrap") {
+		t.Fatalf("stack not captured %s", StackOf(err))
+	}
+	if strings.Contains(err.Error(), "TestWrap") || strings.Contains(err.Error(), "dial fail") {
+		t.Fatalf("stack and cause should not be serialized %s", err.Error())
+	}
+}
+
+func TestStatusDetails(t *testing.T) {
+	err := BadRequest("invalid").(*NetError).
+		WithDetail("check params").
+		WithViolations(&FieldViolation{Field: "name", Description: "required"}).
+		WithRetry(time.Second)
+	merr := FromError(status.Convert(err).Err())
+	if merr.Status != 400 || merr.Detail != "check params" {
+		t.Fatalf("invalid conversation %v", merr)
+	}
+	if len(merr.Violations) != 1 || merr.Violations[0].Field != "name" || merr.Retry == nil || merr.Retry.DelayMs != 1000 {
+		t.Fatalf("invalid details %v", merr)
+	}
+}
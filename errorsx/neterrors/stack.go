@@ -0,0 +1,55 @@
+package neterrors
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+var (
+	// 开启后Wrap时记录调用栈, 用于内部日志
+	CaptureStack = false
+)
+
+const maxStackDepth = 32
+
+type Stack []uintptr
+
+// Callers 记录调用栈, skip为跳过的调用方层数
+func Callers(skip int) Stack {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+func (s Stack) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	frames := runtime.CallersFrames(s)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// StackOf 返回错误链中记录的调用栈, 用于日志
+func StackOf(err error) string {
+	var s interface{ Stack() string }
+	for err != nil {
+		if !errors.As(err, &s) {
+			return ""
+		}
+		if st := s.Stack(); st != "" {
+			return st
+		}
+		err = errors.Unwrap(s.(error))
+	}
+	return ""
+}
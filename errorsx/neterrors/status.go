@@ -0,0 +1,157 @@
+package neterrors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// grpc错误详情ErrorInfo的domain及reason
+	ErrorInfoDomain = "vkit"
+	ErrorInfoReason = "NET_ERROR"
+)
+
+// GRPCStatus 服务端返回NetError时由grpc调用, 按http状态码映射grpc错误码
+// 详情中ErrorInfo携带code、status及detail, 校验字段及重试间隔分别放入BadRequest、RetryInfo, 客户端可无损还原
+func (e *NetError) GRPCStatus() *status.Status {
+	st := status.New(GRPCCode(e.Status), e.Msg)
+	info := &errdetails.ErrorInfo{
+		Reason: ErrorInfoReason,
+		Domain: ErrorInfoDomain,
+		Metadata: map[string]string{
+			"code":   strconv.Itoa(int(e.Code)),
+			"status": strconv.Itoa(int(e.Status)),
+		},
+	}
+	if e.Detail != "" {
+		info.Metadata["detail"] = e.Detail
+	}
+	details := []protoadapt.MessageV1{info}
+	if len(e.Violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, br)
+	}
+	if e.Retry != nil {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(time.Duration(e.Retry.DelayMs) * time.Millisecond),
+		})
+	}
+
+	dst, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return dst
+}
+
+// FromStatus 还原grpc错误, 非vkit服务的错误按grpc错误码映射http状态码
+func FromStatus(st *status.Status) *NetError {
+	if st == nil || st.Code() == codes.OK {
+		return nil
+	}
+
+	e := &NetError{
+		Code:   -1,
+		Status: HTTPStatus(st.Code()),
+		Msg:    st.Message(),
+	}
+	found := false
+	for _, d := range st.Details() {
+		switch v := d.(type) {
+		case *errdetails.ErrorInfo:
+			if v.Domain != ErrorInfoDomain || v.Reason != ErrorInfoReason {
+				continue
+			}
+			code, _ := strconv.Atoi(v.Metadata["code"])
+			status, _ := strconv.Atoi(v.Metadata["status"])
+			e.Code, e.Status, e.Detail = int32(code), int32(status), v.Metadata["detail"]
+			found = true
+		case *errdetails.BadRequest:
+			for _, fv := range v.FieldViolations {
+				e.Violations = append(e.Violations, &FieldViolation{Field: fv.Field, Description: fv.Description})
+			}
+		case *errdetails.RetryInfo:
+			e.Retry = &RetryInfo{DelayMs: v.RetryDelay.AsDuration().Milliseconds()}
+		}
+	}
+
+	// 旧版本服务端将NetError的json作为message返回
+	if !found && st.Code() == codes.Unknown && strings.HasPrefix(st.Message(), "{\"") {
+		return Parse(st.Message())
+	}
+	return e
+}
+
+// GRPCCode http状态码映射为grpc错误码, 业务错误(200)为Unknown
+func GRPCCode(httpStatus int32) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.Unknown
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusInternalServerError:
+		return codes.Internal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
+
+// HTTPStatus grpc错误码映射为http状态码
+func HTTPStatus(code codes.Code) int32 {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
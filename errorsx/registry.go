@@ -0,0 +1,198 @@
+package errorsx
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// 默认语言, 即NewErrno时的消息语言
+	DefaultLang = "zh"
+
+	registry = &errnoRegistry{errnos: make(map[int32]*Errno), messages: make(map[int32]map[string]string)}
+)
+
+type errnoRegistry struct {
+	sync.RWMutex
+	errnos map[int32]*Errno
+	// code -> lang -> msg
+	messages map[int32]map[string]string
+}
+
+// CatalogEntry 错误码目录项
+type CatalogEntry struct {
+	Project  int32             `json:"project"`
+	Code     int32             `json:"code"`
+	Msg      string            `json:"msg"`
+	Messages map[string]string `json:"messages,omitempty"`
+}
+
+// register 登记错误码, 重复时panic, 避免不同模块错误码冲突
+func (r *errnoRegistry) register(e *Errno) {
+	r.Lock()
+	defer r.Unlock()
+	if old, ok := r.errnos[e.Code]; ok {
+		panic(fmt.Sprintf("errno %d duplicate, msg:%s conflicts with msg:%s", e.Code, e.Msg, old.Msg))
+	}
+	r.errnos[e.Code] = e
+}
+
+// Translate 登记错误码在lang下的消息, 如PARAM_ERR.Translate("en", "Invalid parameter")
+func (e *Errno) Translate(lang, msg string) *Errno {
+	registry.Lock()
+	defer registry.Unlock()
+	m, ok := registry.messages[e.Code]
+	if !ok {
+		m = make(map[string]string)
+		registry.messages[e.Code] = m
+	}
+	m[strings.ToLower(lang)] = msg
+	return e
+}
+
+// LoadMessages 批量登记lang下的消息, 如从翻译文件加载, 存在未登记的错误码时返回error
+func LoadMessages(lang string, msgs map[int32]string) error {
+	for code := range msgs {
+		if _, ok := Lookup(code); !ok {
+			return fmt.Errorf("errno %d not registered", code)
+		}
+	}
+	for code, msg := range msgs {
+		e, _ := Lookup(code)
+		e.Translate(lang, msg)
+	}
+	return nil
+}
+
+// Lookup 按错误码查找登记的错误
+func Lookup(code int32) (*Errno, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	e, ok := registry.errnos[code]
+	return e, ok
+}
+
+// Localize 按Accept-Language返回错误码的消息
+// 仅当msg为登记时的默认消息才翻译, Fail等自定义的消息原样返回
+func Localize(code int32, msg string, acceptLanguage string) string {
+	e, ok := Lookup(code)
+	if !ok || e.Msg != msg {
+		return msg
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+	m := registry.messages[code]
+	for _, lang := range parseAcceptLanguage(acceptLanguage) {
+		if v, ok := m[lang]; ok {
+			return v
+		}
+		base, _, _ := strings.Cut(lang, "-")
+		if base == DefaultLang {
+			return msg
+		}
+		if v, ok := m[base]; ok {
+			return v
+		}
+	}
+	return msg
+}
+
+// parseAcceptLanguage 按q值从高到低返回语言, 如"en-US,en;q=0.9"
+func parseAcceptLanguage(v string) []string {
+	type item struct {
+		lang string
+		q    float64
+	}
+	items := make([]item, 0)
+	for _, part := range strings.Split(v, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if p, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(p, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			items = append(items, item{lang, q})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].q > items[j].q })
+
+	langs := make([]string, 0, len(items))
+	for _, it := range items {
+		langs = append(langs, it.lang)
+	}
+	return langs
+}
+
+// Catalog 返回按错误码排序的错误码目录
+func Catalog() []*CatalogEntry {
+	registry.RLock()
+	defer registry.RUnlock()
+	entries := make([]*CatalogEntry, 0, len(registry.errnos))
+	for code, e := range registry.errnos {
+		entry := &CatalogEntry{Project: e.Project, Code: code, Msg: e.Msg}
+		if m := registry.messages[code]; len(m) > 0 {
+			entry.Messages = make(map[string]string, len(m))
+			for k, v := range m {
+				entry.Messages[k] = v
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Code < entries[j].Code })
+	return entries
+}
+
+// CatalogJSON 导出json格式的错误码目录, 供客户端使用
+func CatalogJSON() ([]byte, error) {
+	return json.MarshalIndent(Catalog(), "", "  ")
+}
+
+// CatalogMarkdown 导出markdown表格格式的错误码目录, 每种翻译语言一列
+func CatalogMarkdown() string {
+	entries := Catalog()
+	langSet := make(map[string]bool)
+	for _, e := range entries {
+		for lang := range e.Messages {
+			langSet[lang] = true
+		}
+	}
+	langs := make([]string, 0, len(langSet))
+	for lang := range langSet {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	var sb strings.Builder
+	sb.WriteString("| project | code | msg |")
+	for _, lang := range langs {
+		sb.WriteString(" " + lang + " |")
+	}
+	sb.WriteString("\n| --- | --- | --- |")
+	for range langs {
+		sb.WriteString(" --- |")
+	}
+	sb.WriteString("\n")
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "| %d | %d | %s |", e.Project, e.Code, escapeCell(e.Msg))
+		for _, lang := range langs {
+			sb.WriteString(" " + escapeCell(e.Messages[lang]) + " |")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
@@ -0,0 +1,411 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcclient"
+	"github.com/vison888/go-vkit/logger"
+	meta "github.com/vison888/go-vkit/metadata"
+)
+
+const (
+	// 整个请求失败, 缺省
+	AggregateOnErrorFail = "fail"
+	// 结果中省略, 错误写入_errors
+	AggregateOnErrorSkip = "skip"
+	// 使用Default作为结果, 错误写入_errors
+	AggregateOnErrorDefault = "default"
+)
+
+const (
+	// 引用客户端请求, POST为json请求体, GET为查询参数
+	aggregateRefRequest = "request"
+	aggregateErrorsKey  = "_errors"
+)
+
+// AggregateConfig 组合接口, 将多个grpc调用的结果合并为一个json对象, key为调用名
+// 无依赖的调用并行执行, 有依赖的调用在依赖完成后执行
+type AggregateConfig struct {
+	Calls []*AggregateCall `json:"calls" yaml:"calls"`
+}
+
+type AggregateCall struct {
+	// 结果字段名
+	Name    string `json:"name" yaml:"name"`
+	Service string `json:"service" yaml:"service"`
+	// Struct.Method
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// 请求体, 为空时转发客户端请求; 以$开头的字符串为引用, 如$request.id、$user.id, 引用其他调用时自动加入依赖
+	Body map[string]any `json:"body,omitempty" yaml:"body,omitempty"`
+	// 无需引用结果但要求先执行的调用
+	DependsOn []string `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty"`
+	// 单个调用超时, 0为只受整体超时限制
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// fail(缺省)、skip、default, 依赖失败时同样按此处理
+	OnError string `json:"onError,omitempty" yaml:"onError,omitempty"`
+	Default any    `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+func (c *AggregateConfig) Validate() error {
+	if len(c.Calls) == 0 {
+		return fmt.Errorf("aggregate calls are required")
+	}
+	names := make(map[string]*AggregateCall)
+	for _, call := range c.Calls {
+		if call.Name == "" || call.Service == "" || call.Endpoint == "" {
+			return fmt.Errorf("aggregate call name, service and endpoint are required")
+		}
+		if call.Name == aggregateRefRequest || call.Name == aggregateErrorsKey || strings.Contains(call.Name, ".") {
+			return fmt.Errorf("aggregate call name %s not allowed", call.Name)
+		}
+		if names[call.Name] != nil {
+			return fmt.Errorf("aggregate call %s duplicated", call.Name)
+		}
+		switch call.OnError {
+		case "", AggregateOnErrorFail, AggregateOnErrorSkip, AggregateOnErrorDefault:
+		default:
+			return fmt.Errorf("aggregate call %s: onError %s not support", call.Name, call.OnError)
+		}
+		if call.Timeout < 0 {
+			return fmt.Errorf("aggregate call %s: timeout should not be negative", call.Name)
+		}
+		names[call.Name] = call
+	}
+	for _, call := range c.Calls {
+		for _, dep := range call.deps() {
+			if names[dep] == nil {
+				return fmt.Errorf("aggregate call %s: dependency %s not found", call.Name, dep)
+			}
+		}
+	}
+
+	// 检查循环依赖
+	state := make(map[string]int)
+	var visit func(call *AggregateCall) error
+	visit = func(call *AggregateCall) error {
+		switch state[call.Name] {
+		case 1:
+			return fmt.Errorf("aggregate call %s: dependency cycle", call.Name)
+		case 2:
+			return nil
+		}
+		state[call.Name] = 1
+		for _, dep := range call.deps() {
+			if err := visit(names[dep]); err != nil {
+				return err
+			}
+		}
+		state[call.Name] = 2
+		return nil
+	}
+	for _, call := range c.Calls {
+		if err := visit(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deps DependsOn及请求体中引用的调用
+func (call *AggregateCall) deps() []string {
+	seen := make(map[string]bool)
+	deps := make([]string, 0, len(call.DependsOn))
+	add := func(name string) {
+		if name != aggregateRefRequest && !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	for _, dep := range call.DependsOn {
+		add(dep)
+	}
+	walkRefs(call.Body, func(ref string) {
+		name, _, _ := strings.Cut(ref, ".")
+		add(name)
+	})
+	return deps
+}
+
+func walkRefs(v any, fn func(ref string)) {
+	switch t := v.(type) {
+	case string:
+		if strings.HasPrefix(t, "$") {
+			fn(t[1:])
+		}
+	case map[string]any:
+		for _, item := range t {
+			walkRefs(item, fn)
+		}
+	case []any:
+		for _, item := range t {
+			walkRefs(item, fn)
+		}
+	}
+}
+
+// resolveRefs 替换引用, 引用不存在时为null
+func resolveRefs(v any, values map[string]any) any {
+	switch t := v.(type) {
+	case string:
+		if !strings.HasPrefix(t, "$") {
+			return t
+		}
+		name, path, _ := strings.Cut(t[1:], ".")
+		root, ok := values[name]
+		if !ok || path == "" {
+			return root
+		}
+		obj, ok := root.(map[string]any)
+		if !ok {
+			return nil
+		}
+		val, _ := getPath(obj, path)
+		return val
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, item := range t {
+			m[k] = resolveRefs(item, values)
+		}
+		return m
+	case []any:
+		s := make([]any, len(t))
+		for i, item := range t {
+			s[i] = resolveRefs(item, values)
+		}
+		return s
+	}
+	return v
+}
+
+type aggregateResult struct {
+	done  chan struct{}
+	value any
+	err   *neterrors.NetError
+}
+
+type AggregateHandler struct {
+	cfg  *AggregateConfig
+	opts HttpOptions
+}
+
+// NewAggregateHandler cfg需先通过Validate
+func NewAggregateHandler(cfg *AggregateConfig, opts ...HttpOption) *AggregateHandler {
+	return &AggregateHandler{
+		cfg:  cfg,
+		opts: newHttpOptions(opts...),
+	}
+}
+
+func (h *AggregateHandler) Init(opts ...HttpOption) {
+	for _, o := range opts {
+		o(&h.opts)
+	}
+}
+
+func (h *AggregateHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if re := recover(); re != nil {
+			if h.opts.ErrHandler != nil {
+				h.opts.ErrHandler(w, r, re)
+			}
+		}
+	}()
+
+	method := strings.ToUpper(r.Method)
+	if method != "POST" && method != "GET" {
+		ErrorResponse(w, r, neterrors.MethodNotAllowed("[gate] req method:%s not support url:%s", method, r.RequestURI))
+		return
+	}
+
+	stripHeaders(r, h.opts.TrustedHeaders)
+	// 鉴权
+	if h.opts.AuthHandler != nil {
+		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
+			ErrorResponse(w, r, cerr)
+			return
+		}
+	}
+
+	md := meta.Metadata{}
+	md["x-content-type"] = "application/json"
+
+	request := &HttpRequest{
+		uri:         r.RequestURI,
+		r:           r,
+		method:      method,
+		contentType: "application/json",
+		body:        nil,
+		hasRead:     false,
+	}
+
+	response := &HttpResponse{
+		w:        w,
+		header:   nil,
+		hasWrite: false,
+		content:  nil,
+	}
+
+	fullCtx, cancel := withTimeout(requestToContext(r.Context(), md, r), r, h.opts.Timeout)
+	defer cancel()
+	// 主逻辑
+	fn := func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+		input, err := h.input(req)
+		if err != nil {
+			return neterrors.BadRequest("[gate] %s url:%s", err.Error(), r.RequestURI)
+		}
+		content, netErr := h.invoke(ctx, r, input)
+		if netErr != nil {
+			return netErr
+		}
+		resp.content = content
+		return nil
+	}
+	// 拦截器
+	fn = h.opts.wrap(fn)
+	writeResult(fullCtx, w, r, response, fn(fullCtx, request, response))
+}
+
+// input 客户端请求, 用于$request引用及未配置Body的调用
+func (h *AggregateHandler) input(req *HttpRequest) (map[string]any, error) {
+	if req.method == "GET" {
+		obj := make(map[string]any)
+		for k, v := range req.Request().URL.Query() {
+			obj[k] = strings.Join(v, ",")
+		}
+		return obj, nil
+	}
+	body, _, err := req.Read()
+	if err != nil {
+		return nil, err
+	}
+	return decodeObject(body)
+}
+
+// invoke 每个调用一个协程, 等待依赖完成后执行; fail策略的调用失败时取消其他调用
+func (h *AggregateHandler) invoke(ctx context.Context, r *http.Request, input map[string]any) ([]byte, *neterrors.NetError) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(map[string]*aggregateResult, len(h.cfg.Calls))
+	for _, call := range h.cfg.Calls {
+		results[call.Name] = &aggregateResult{done: make(chan struct{})}
+	}
+	failed := make(chan *neterrors.NetError, len(h.cfg.Calls))
+	for _, call := range h.cfg.Calls {
+		go func(call *AggregateCall) {
+			res := results[call.Name]
+			defer close(res.done)
+			defer func() {
+				if re := recover(); re != nil {
+					logger.Errorf("[gate] aggregate call:%s panic recovered:%v", call.Name, re)
+					res.err = neterrors.FromError(neterrors.InternalServerError("[gate] aggregate call:%s panic", call.Name))
+					h.onError(call, res, failed)
+				}
+			}()
+			res.value, res.err = h.call(ctx, r, call, input, results)
+			if res.err != nil {
+				h.onError(call, res, failed)
+			}
+		}(call)
+	}
+
+	for _, call := range h.cfg.Calls {
+		select {
+		case <-results[call.Name].done:
+		case netErr := <-failed:
+			return nil, netErr
+		}
+	}
+	select {
+	case netErr := <-failed:
+		return nil, netErr
+	default:
+	}
+
+	out := make(map[string]any, len(results)+1)
+	errs := make(map[string]*neterrors.NetError)
+	for _, call := range h.cfg.Calls {
+		res := results[call.Name]
+		if res.err != nil {
+			errs[call.Name] = res.err
+			if call.OnError != AggregateOnErrorDefault {
+				continue
+			}
+		}
+		out[call.Name] = res.value
+	}
+	if len(errs) > 0 {
+		out[aggregateErrorsKey] = errs
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, neterrors.FromError(neterrors.InternalServerError("[gate] aggregate marshal fail:%s", err))
+	}
+	return b, nil
+}
+
+func (h *AggregateHandler) onError(call *AggregateCall, res *aggregateResult, failed chan<- *neterrors.NetError) {
+	switch call.OnError {
+	case AggregateOnErrorSkip:
+		res.value = nil
+	case AggregateOnErrorDefault:
+		res.value = call.Default
+	default:
+		failed <- res.err
+	}
+}
+
+func (h *AggregateHandler) call(ctx context.Context, r *http.Request, call *AggregateCall, input map[string]any, results map[string]*aggregateResult) (any, *neterrors.NetError) {
+	values := map[string]any{aggregateRefRequest: input}
+	for _, dep := range call.deps() {
+		res := results[dep]
+		select {
+		case <-res.done:
+		case <-ctx.Done():
+			return nil, neterrors.FromError(neterrors.GatewayTimeout("[gate] aggregate call:%s canceled", call.Name))
+		}
+		if res.err != nil {
+			return nil, neterrors.FromError(neterrors.BadGateway("[gate] aggregate call:%s dependency %s failed", call.Name, dep))
+		}
+		values[dep] = res.value
+	}
+
+	var body any = input
+	if call.Body != nil {
+		body = resolveRefs(call.Body, values)
+	}
+	reqBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, neterrors.FromError(neterrors.BadRequest("[gate] aggregate call:%s marshal fail:%s", call.Name, err))
+	}
+
+	if call.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(call.Timeout))
+		defer cancel()
+	}
+	target := h.opts.route(ctx, call.Service, r)
+	jsonRaw, netErr := grpcclient.InvokeByGate(ctx, target, call.Service, call.Endpoint, reqBytes)
+	if netErr != nil {
+		logger.Infof("[gate] aggregate call:%s response netErr:%s", call.Name, netErr)
+		return nil, netErr
+	}
+
+	var value any
+	if jsonRaw != nil && len(*jsonRaw) > 0 {
+		// 数字保持原样, 避免int64精度丢失
+		dec := json.NewDecoder(bytes.NewReader(*jsonRaw))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			return nil, neterrors.FromError(neterrors.BadGateway("[gate] aggregate call:%s unmarshal fail:%s", call.Name, err))
+		}
+	}
+	return value, nil
+}
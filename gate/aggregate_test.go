@@ -0,0 +1,92 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+func TestAggregate(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+		grpcserver.Handle(svr, "EchoService.Fail", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			return neterrors.Forbidden("no permission")
+		})
+		grpcserver.Handle(svr, "EchoService.Slow", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			time.Sleep(time.Millisecond * 500)
+			resp.Msg = "slow"
+			return nil
+		})
+	})
+	cfg := &AggregateConfig{Calls: []*AggregateCall{
+		{Name: "greet", Service: "echo", Endpoint: "EchoService.Hello", Body: map[string]any{"name": "$request.name"}},
+		// 依赖greet的结果
+		{Name: "again", Service: "echo", Endpoint: "EchoService.Hello", Body: map[string]any{"name": "$greet.msg"}},
+		{Name: "bad", Service: "echo", Endpoint: "EchoService.Fail", OnError: AggregateOnErrorSkip},
+		{Name: "slow", Service: "echo", Endpoint: "EchoService.Slow", Timeout: Duration(time.Millisecond * 50),
+			OnError: AggregateOnErrorDefault, Default: map[string]any{"msg": "default"}},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	target := HttpTarget(func(service string) string { return addr })
+
+	start := time.Now()
+	h := NewAggregateHandler(cfg, target)
+	r := httptest.NewRequest(http.MethodGet, "/home?name=bob", nil)
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	// 响应头在WriteHeader前设置
+	if w.Code != 200 || w.Result().Header.Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Fatalf("unexpected resp %d %v %s", w.Code, w.Result().Header, w.Body.String())
+	}
+	if time.Since(start) > time.Millisecond*400 {
+		t.Fatalf("calls should run in parallel and respect timeout, cost:%s", time.Since(start))
+	}
+	var out struct {
+		Greet  echoResp                       `json:"greet"`
+		Again  echoResp                       `json:"again"`
+		Bad    *echoResp                      `json:"bad"`
+		Slow   echoResp                       `json:"slow"`
+		Errors map[string]*neterrors.NetError `json:"_errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Greet.Msg != "hello bob" || out.Again.Msg != "hello hello bob" || out.Bad != nil || out.Slow.Msg != "default" {
+		t.Fatalf("unexpected result %s", w.Body.String())
+	}
+	if out.Errors["bad"] == nil || out.Errors["bad"].Status != 403 || out.Errors["slow"] == nil || len(out.Errors) != 2 {
+		t.Fatalf("unexpected errors %s", w.Body.String())
+	}
+
+	// fail策略的调用失败时整个请求失败
+	cfg.Calls[2].OnError = AggregateOnErrorFail
+	h = NewAggregateHandler(cfg, target)
+	r = httptest.NewRequest(http.MethodPost, "/home", strings.NewReader(`{"name":"bob"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 403 {
+		t.Fatalf("unexpected resp %d %s", w.Code, w.Body.String())
+	}
+
+	cycle := &AggregateConfig{Calls: []*AggregateCall{
+		{Name: "a", Service: "echo", Endpoint: "EchoService.Hello", Body: map[string]any{"name": "$b.msg"}},
+		{Name: "b", Service: "echo", Endpoint: "EchoService.Hello", DependsOn: []string{"a"}},
+	}}
+	if err := cycle.Validate(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("cycle should fail %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package gate
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/logger"
+	"github.com/vison888/go-vkit/redisx"
+)
+
+var (
+	DefaultCacheMaxEntries = 10000
+	// 携带调用方凭证的请求头, 响应可能因调用方而不同
+	CredentialHeaders = []string{"Authorization", "Cookie"}
+)
+
+// CacheEntry 缓存的成功响应
+type CacheEntry struct {
+	Body []byte `json:"body"`
+	ETag string `json:"etag"`
+	// 写入时各标签的版本, 标签失效后版本增加, 缓存随之失效
+	Tags    map[string]int64 `json:"tags"`
+	Expires time.Time        `json:"expires"`
+}
+
+// CacheStorage 响应缓存存储
+type CacheStorage interface {
+	// Get 未命中时返回nil
+	Get(ctx context.Context, key string) (*CacheEntry, error)
+	Set(ctx context.Context, key string, e *CacheEntry, ttl time.Duration) error
+	// TagVersions 返回标签的当前版本, 未失效过的标签为0
+	TagVersions(ctx context.Context, tags []string) (map[string]int64, error)
+	// BumpTags 使标签下的缓存失效
+	BumpTags(ctx context.Context, tags []string) error
+}
+
+// CacheRouteConfig 按服务或服务/接口设置的缓存
+type CacheRouteConfig struct {
+	TTL time.Duration
+	// 缓存标签, 服务名默认作为标签
+	Tags []string
+	// 响应与调用方无关, 携带凭证的请求也共享同一缓存
+	Public bool
+}
+
+type CacheOptions struct {
+	Store CacheStorage
+	// key为服务或服务/接口, 未配置的接口不缓存
+	Routes map[string]*CacheRouteConfig
+	// 参与缓存键的请求头, 如Authorization、Accept-Language
+	// 携带凭证的请求仅在凭证头参与缓存键或路由为Public时缓存
+	VaryHeaders []string
+}
+
+type CacheOption func(o *CacheOptions)
+
+func newCacheOptions(opts ...CacheOption) CacheOptions {
+	opt := CacheOptions{
+		Routes: make(map[string]*CacheRouteConfig),
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Store == nil {
+		opt.Store = NewMemoryCacheStore(DefaultCacheMaxEntries)
+	}
+	return opt
+}
+
+func CacheStore(s CacheStorage) CacheOption {
+	return func(o *CacheOptions) {
+		o.Store = s
+	}
+}
+
+// CacheRoute 缓存route的成功响应ttl时长, route为服务名或服务名/接口, 如sso、sso/AuthService.GetUser
+func CacheRoute(route string, ttl time.Duration, tags ...string) CacheOption {
+	return func(o *CacheOptions) {
+		o.Routes[route] = &CacheRouteConfig{TTL: ttl, Tags: tags}
+	}
+}
+
+// CachePublicRoute 同CacheRoute, 响应与调用方无关, 所有调用方共享缓存
+func CachePublicRoute(route string, ttl time.Duration, tags ...string) CacheOption {
+	return func(o *CacheOptions) {
+		o.Routes[route] = &CacheRouteConfig{TTL: ttl, Tags: tags, Public: true}
+	}
+}
+
+func CacheVaryHeaders(headers ...string) CacheOption {
+	return func(o *CacheOptions) {
+		o.VaryHeaders = append(o.VaryHeaders, headers...)
+	}
+}
+
+// ResponseCache 网关响应缓存, 按路由、请求体摘要及VaryHeaders缓存只读接口的成功响应
+type ResponseCache struct {
+	opts CacheOptions
+}
+
+func NewResponseCache(opts ...CacheOption) *ResponseCache {
+	return &ResponseCache{opts: newCacheOptions(opts...)}
+}
+
+// route 接口级配置优先于服务级
+func (c *ResponseCache) route(service, endpoint string) *CacheRouteConfig {
+	if rc, ok := c.opts.Routes[service+"/"+endpoint]; ok {
+		return rc
+	}
+	return c.opts.Routes[service]
+}
+
+func (c *ResponseCache) key(req *HttpRequest, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%s\n%s\n", req.Service(), req.Endpoint(), req.ContentType())
+	h.Write(body)
+	for _, name := range c.opts.VaryHeaders {
+		fmt.Fprintf(h, "\n%s:%s", strings.ToLower(name), req.Request().Header.Get(name))
+	}
+	return req.Service() + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Wrapper 用于GrpcHandler的HttpWrapHandler
+// 请求头Cache-Control为no-cache时跳过读取缓存, no-store时不读写缓存; If-None-Match与ETag一致时返回304
+func (c *ResponseCache) Wrapper() HandlerWrapper {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+			rc := c.route(req.Service(), req.Endpoint())
+			cc := req.Request().Header.Get("Cache-Control")
+			if rc == nil || rc.TTL <= 0 || strings.Contains(cc, "no-store") {
+				return next(ctx, req, resp)
+			}
+
+			shared, ok := c.scope(req.Request(), rc)
+			if !ok {
+				return next(ctx, req, resp)
+			}
+
+			body, _, err := req.Read()
+			if err != nil {
+				return neterrors.BadRequest("[gate] %s url:%s", err.Error(), req.Uri())
+			}
+			key := c.key(req, body)
+			tags := append([]string{req.Service()}, rc.Tags...)
+
+			if !strings.Contains(cc, "no-cache") {
+				if e := c.lookup(ctx, key); e != nil {
+					resp.w.Header().Set("X-Cache", "HIT")
+					return c.serve(req, resp, e, shared)
+				}
+			}
+
+			// 调用前记录标签版本, 调用期间标签失效时结果不会被当作新数据
+			versions, err := c.opts.Store.TagVersions(ctx, tags)
+			if err != nil {
+				logger.Errorf("[gate] cache tag versions fail:%s", err)
+				return next(ctx, req, resp)
+			}
+			if appErr := next(ctx, req, resp); appErr != nil || resp.hasWrite {
+				return appErr
+			}
+
+			sum := sha256.Sum256(resp.content)
+			e := &CacheEntry{
+				Body:    resp.content,
+				ETag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+				Tags:    versions,
+				Expires: time.Now().Add(rc.TTL),
+			}
+			if err := c.opts.Store.Set(context.Background(), key, e, rc.TTL); err != nil {
+				logger.Errorf("[gate] cache set key:%s fail:%s", key, err)
+			}
+			resp.w.Header().Set("X-Cache", "MISS")
+			return c.serve(req, resp, e, shared)
+		}
+	}
+}
+
+// scope 请求能否缓存及缓存是否由所有调用方共享
+// 携带凭证的请求在凭证头未参与缓存键时不缓存, 避免把某个用户的响应返回给其他用户
+func (c *ResponseCache) scope(r *http.Request, rc *CacheRouteConfig) (shared bool, ok bool) {
+	if rc.Public {
+		return true, true
+	}
+	shared = true
+	for _, name := range CredentialHeaders {
+		if r.Header.Get(name) == "" {
+			continue
+		}
+		if !c.varies(name) {
+			return false, false
+		}
+		shared = false
+	}
+	return shared, true
+}
+
+func (c *ResponseCache) varies(name string) bool {
+	for _, h := range c.opts.VaryHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup 命中且标签未失效时返回缓存
+func (c *ResponseCache) lookup(ctx context.Context, key string) *CacheEntry {
+	e, err := c.opts.Store.Get(ctx, key)
+	if err != nil {
+		logger.Errorf("[gate] cache get key:%s fail:%s", key, err)
+		return nil
+	}
+	if e == nil || time.Now().After(e.Expires) {
+		return nil
+	}
+	tags := make([]string, 0, len(e.Tags))
+	for tag := range e.Tags {
+		tags = append(tags, tag)
+	}
+	versions, err := c.opts.Store.TagVersions(ctx, tags)
+	if err != nil {
+		logger.Errorf("[gate] cache tag versions fail:%s", err)
+		return nil
+	}
+	for tag, v := range e.Tags {
+		if versions[tag] != v {
+			return nil
+		}
+	}
+	return e
+}
+
+// serve shared为false时缓存按凭证区分, 标记为private
+func (c *ResponseCache) serve(req *HttpRequest, resp *HttpResponse, e *CacheEntry, shared bool) error {
+	hdr := resp.w.Header()
+	hdr.Set("ETag", e.ETag)
+	maxAge := int(time.Until(e.Expires).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	scope := "public"
+	if !shared {
+		scope = "private"
+	}
+	hdr.Set("Cache-Control", scope+", max-age="+strconv.Itoa(maxAge))
+
+	if etagMatch(req.Request().Header.Get("If-None-Match"), e.ETag) {
+		resp.w.WriteHeader(http.StatusNotModified)
+		resp.hasWrite = true
+		return nil
+	}
+	resp.content = e.Body
+	return nil
+}
+
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Invalidate 使标签下的缓存失效, 服务写数据后调用
+func (c *ResponseCache) Invalidate(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	return c.opts.Store.BumpTags(ctx, tags)
+}
+
+type cacheInvalidateReq struct {
+	Tags []string `json:"tags"`
+}
+
+// InvalidateHandler 缓存失效接口, POST {"tags":["sso"]}, 应只暴露在内网监听
+func (c *ResponseCache) InvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ErrorResponse(w, r, neterrors.MethodNotAllowed("[gate] req method:%s not support url:%s", r.Method, r.RequestURI))
+		return
+	}
+	req := &cacheInvalidateReq{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		ErrorResponse(w, r, neterrors.BadRequest("[gate] %s", err.Error()))
+		return
+	}
+	if err := c.Invalidate(r.Context(), req.Tags...); err != nil {
+		logger.Errorf("[gate] cache invalidate tags:%v fail:%s", req.Tags, err)
+		ErrorResponse(w, r, neterrors.ServiceUnavailable("[gate] cache invalidate fail"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(`{"code":0}`))
+}
+
+type memoryCacheStore struct {
+	sync.Mutex
+	max      int
+	ll       *list.List
+	items    map[string]*list.Element
+	versions map[string]int64
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStore 进程内LRU存储, 超过maxEntries时淘汰最久未使用的缓存
+func NewMemoryCacheStore(maxEntries int) CacheStorage {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	return &memoryCacheStore{
+		max:      maxEntries,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		versions: make(map[string]int64),
+	}
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	s.Lock()
+	defer s.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if time.Now().After(item.entry.Expires) {
+		s.ll.Remove(el)
+		delete(s.items, key)
+		return nil, nil
+	}
+	s.ll.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, e *CacheEntry, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if el, ok := s.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = e
+		s.ll.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&memoryCacheItem{key: key, entry: e})
+	for s.ll.Len() > s.max {
+		el := s.ll.Back()
+		s.ll.Remove(el)
+		delete(s.items, el.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) TagVersions(ctx context.Context, tags []string) (map[string]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	versions := make(map[string]int64, len(tags))
+	for _, tag := range tags {
+		versions[tag] = s.versions[tag]
+	}
+	return versions, nil
+}
+
+func (s *memoryCacheStore) BumpTags(ctx context.Context, tags []string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, tag := range tags {
+		s.versions[tag]++
+	}
+	return nil
+}
+
+type redisCacheStore struct {
+	c      *redisx.RedisClient
+	prefix string
+	tagKey *redisx.RedisKey
+}
+
+// NewRedisCacheStore redis存储, 多实例网关共享缓存及失效, key为prefix:服务名:摘要, 标签版本为prefix:tag:标签
+func NewRedisCacheStore(c *redisx.RedisClient, prefix string) CacheStorage {
+	return &redisCacheStore{c: c, prefix: prefix, tagKey: &redisx.RedisKey{Code: prefix + ":tag"}}
+}
+
+func (s *redisCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	e := &CacheEntry{}
+	err := s.c.GetJson(&redisx.RedisKey{Code: s.prefix}, key, e)
+	if redisx.IsNil(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *redisCacheStore) Set(ctx context.Context, key string, e *CacheEntry, ttl time.Duration) error {
+	return s.c.SetJson(&redisx.RedisKey{Code: s.prefix, Expire: ttl}, key, e)
+}
+
+func (s *redisCacheStore) TagVersions(ctx context.Context, tags []string) (map[string]int64, error) {
+	versions := make(map[string]int64, len(tags))
+	for _, tag := range tags {
+		v, err := s.c.GetInt64(s.tagKey, tag)
+		if err != nil && !redisx.IsNil(err) {
+			return nil, err
+		}
+		versions[tag] = v
+	}
+	return versions, nil
+}
+
+func (s *redisCacheStore) BumpTags(ctx context.Context, tags []string) error {
+	for _, tag := range tags {
+		if _, err := s.c.IncrBy(s.tagKey, tag, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+func TestResponseCache(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+	})
+	target := HttpTarget(func(service string) string { return addr })
+	cache := NewResponseCache(
+		CacheRoute("echo/EchoService.Hello", time.Minute, "user"),
+		CacheVaryHeaders("Accept-Language"))
+	h := NewGrpcHandler(target, HttpWrapHandler(cache.Wrapper()))
+
+	call := func(body string, hdr map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.Handle(w, r)
+		return w
+	}
+
+	w := call(`{"name":"a"}`, nil)
+	if w.Code != 200 || w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), "hello a") {
+		t.Fatalf("unexpected first resp %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+
+	if w = call(`{"name":"a"}`, nil); w.Header().Get("X-Cache") != "HIT" || !strings.Contains(w.Body.String(), "hello a") {
+		t.Fatalf("should hit cache %v %s", w.Header(), w.Body.String())
+	}
+	// 不同协议的请求体编码相同也不共用缓存
+	wr := httptest.NewRequest(http.MethodPost, "/vkit.echo.EchoService/Hello", bytes.NewReader(encodeWebFrame(0, []byte(`{"name":"a"}`))))
+	wr.Header.Set("Content-Type", "application/grpc-web+json")
+	ww := httptest.NewRecorder()
+	NewGrpcWebHandler(target, HttpWrapHandler(cache.Wrapper())).Handle(ww, wr)
+	if msgs, _ := readWebFrames(t, ww.Body.Bytes()); ww.Header().Get("X-Cache") != "MISS" || len(msgs) != 1 || msgs[0] != `{"msg":"hello a"}` {
+		t.Fatalf("grpc-web request should not share json entry %v %q", ww.Header(), ww.Body.String())
+	}
+	// 请求体或Vary头不同不命中
+	if w = call(`{"name":"b"}`, nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("different body should miss")
+	}
+	if w = call(`{"name":"a"}`, map[string]string{"Accept-Language": "en"}); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("different vary header should miss")
+	}
+	if w = call(`{"name":"a"}`, map[string]string{"Cache-Control": "no-cache"}); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("no-cache should skip lookup")
+	}
+
+	if w = call(`{"name":"a"}`, map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("should be not modified %d %s", w.Code, w.Body.String())
+	}
+
+	// 按标签失效
+	cache.Invalidate(context.Background(), "user")
+	if w = call(`{"name":"a"}`, nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("invalidated entry should miss")
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/cache/invalidate", strings.NewReader(`{"tags":["echo"]}`))
+	iw := httptest.NewRecorder()
+	cache.InvalidateHandler(iw, r)
+	if iw.Code != 200 {
+		t.Fatalf("invalidate fail %d %s", iw.Code, iw.Body.String())
+	}
+	if w = call(`{"name":"a"}`, nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("service tag invalidation should miss")
+	}
+
+	// 携带凭证且凭证头未参与缓存键时不缓存
+	auth := map[string]string{"Authorization": "Bearer u1"}
+	for i := 0; i < 2; i++ {
+		if w = call(`{"name":"a"}`, auth); w.Header().Get("X-Cache") != "" {
+			t.Fatalf("credentialed request should bypass cache %v", w.Header())
+		}
+	}
+
+	// 凭证头参与缓存键时按调用方缓存并标记为private
+	cache = NewResponseCache(CacheRoute("echo/EchoService.Hello", time.Minute), CacheVaryHeaders("Authorization"))
+	h = NewGrpcHandler(target, HttpWrapHandler(cache.Wrapper()))
+	call(`{"name":"a"}`, auth)
+	if w = call(`{"name":"a"}`, auth); w.Header().Get("X-Cache") != "HIT" || !strings.HasPrefix(w.Header().Get("Cache-Control"), "private") {
+		t.Fatalf("should hit per caller cache %v", w.Header())
+	}
+	if w = call(`{"name":"a"}`, map[string]string{"Authorization": "Bearer u2"}); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("other caller should miss")
+	}
+	if w = call(`{"name":"a"}`, nil); w.Header().Get("X-Cache") != "MISS" || !strings.HasPrefix(w.Header().Get("Cache-Control"), "public") {
+		t.Fatalf("anonymous request should use shared cache %v", w.Header())
+	}
+}
+
+func TestMemoryCacheStoreLRU(t *testing.T) {
+	s := NewMemoryCacheStore(2)
+	ctx := context.Background()
+	e := &CacheEntry{Body: []byte("x"), Expires: time.Now().Add(time.Minute)}
+	s.Set(ctx, "a", e, time.Minute)
+	s.Set(ctx, "b", e, time.Minute)
+	s.Get(ctx, "a")
+	s.Set(ctx, "c", e, time.Minute)
+	if v, _ := s.Get(ctx, "b"); v != nil {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if v, _ := s.Get(ctx, "a"); v == nil {
+		t.Fatal("recently used entry should be kept")
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/vison888/go-vkit/errorsx"
 	"github.com/vison888/go-vkit/errorsx/neterrors"
 	"github.com/vison888/go-vkit/grpcx"
 	"github.com/vison888/go-vkit/logger"
@@ -18,6 +20,33 @@ import (
 )
 
 func ErrorResponse(w http.ResponseWriter, r *http.Request, _err error) {
+	ErrorResponseContext(r.Context(), w, r, _err)
+}
+
+// writeResult 写出拦截器链的结果, 出错时返回错误, 拦截器已直接写出(如缓存的304)时跳过, 否则返回json
+func writeResult(ctx context.Context, w http.ResponseWriter, r *http.Request, resp *HttpResponse, appErr error) {
+	if appErr != nil {
+		netErr, ok := appErr.(*neterrors.NetError)
+		if !ok {
+			netErr = neterrors.BadRequest(appErr.Error()).(*neterrors.NetError)
+		}
+		ErrorResponseContext(ctx, w, r, netErr)
+		return
+	}
+	if resp.hasWrite {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.content)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(resp.content); err != nil {
+		logger.Errorf("[gate] response fail url:%v respBytes:%s", r.RequestURI, string(resp.content))
+	}
+}
+
+// ErrorResponseContext 按handler的ctx返回错误, 错误消息按其metadata中的accept-language翻译
+func ErrorResponseContext(ctx context.Context, w http.ResponseWriter, r *http.Request, _err error) {
 	var netErr *neterrors.NetError
 	switch verr := _err.(type) {
 	case *neterrors.NetError:
@@ -30,6 +59,15 @@ func ErrorResponse(w http.ResponseWriter, r *http.Request, _err error) {
 		}
 	}
 
+	// 按客户端语言翻译登记的错误码消息
+	if lang, _ := requestValue(ctx, r, "Accept-Language"); lang != "" {
+		if msg := errorsx.Localize(netErr.Code, netErr.Msg, lang); msg != netErr.Msg {
+			localized := *netErr
+			localized.Msg = msg
+			netErr = &localized
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(int(netErr.Status))
@@ -104,12 +142,69 @@ func requestPayload(r *http.Request) (bytes []byte, fileMap map[string]*grpcx.Fi
 	}
 }
 
+// TimeoutMsHeader 客户端超时请求头, 单位毫秒
+const TimeoutMsHeader = "timeout-ms"
+
+// withTimeout 取路由超时与客户端超时中较小者, 均未设置时不加deadline
+func withTimeout(ctx context.Context, r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if v, ok := clientTimeout(r); ok && (timeout <= 0 || v < timeout) {
+		timeout = v
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// clientTimeout 优先取timeout-ms头(毫秒); timeout头兼容旧客户端, 纯数字为秒, 也可带单位如500ms
+func clientTimeout(r *http.Request) (time.Duration, bool) {
+	if v := r.Header.Get(TimeoutMsHeader); v != "" {
+		return grpcx.ParseTimeout(v)
+	}
+	v := r.Header.Get(grpcx.TimeoutKey)
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, n > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// requestToContext 请求头写入metadata, 客户端超时已转为ctx的deadline, 不再透传
 func requestToContext(ctx context.Context, md meta.Metadata, r *http.Request) context.Context {
 	for k, v := range r.Header {
 		if k == "Connection" {
 			continue
 		}
-		md[strings.ToLower(k)] = strings.Join(v, ",")
+		key := strings.ToLower(k)
+		if key == grpcx.TimeoutKey || key == TimeoutMsHeader {
+			continue
+		}
+		md[key] = strings.Join(v, ",")
 	}
 	return metadata.NewContext(ctx, md)
 }
+
+// stripHeaders 删除客户端传入的受信请求头
+func stripHeaders(r *http.Request, keys []string) {
+	for _, k := range keys {
+		r.Header.Del(k)
+	}
+}
+
+// requestValue 优先取metadata, 鉴权或拦截器可在其中写入如用户id, 其次取请求头
+func requestValue(ctx context.Context, r *http.Request, key string) (string, bool) {
+	if md, ok := meta.FromContext(ctx); ok {
+		if v, ok := md.Get(strings.ToLower(key)); ok && v != "" {
+			return v, true
+		}
+	}
+	if r != nil {
+		if v := r.Header.Get(key); v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
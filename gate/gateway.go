@@ -0,0 +1,408 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/logger"
+)
+
+var (
+	DefaultGatewayReloadInterval  = time.Second * 3
+	DefaultGatewayShutdownTimeout = time.Second * 30
+)
+
+type GatewayOptions struct {
+	// 配置文件检查间隔, 0为不热加载
+	ReloadInterval time.Duration
+	// 下线监听时等待存量请求的时长
+	ShutdownTimeout time.Duration
+	AuthHandlers    map[string]func(w http.ResponseWriter, r *http.Request) error
+	// 所有路由共用的HttpOption
+	HttpOpts []HttpOption
+}
+
+type GatewayOption func(o *GatewayOptions)
+
+func newGatewayOptions(opts ...GatewayOption) GatewayOptions {
+	opt := GatewayOptions{
+		ReloadInterval:  DefaultGatewayReloadInterval,
+		ShutdownTimeout: DefaultGatewayShutdownTimeout,
+		AuthHandlers:    make(map[string]func(w http.ResponseWriter, r *http.Request) error),
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+func GatewayReloadInterval(interval time.Duration) GatewayOption {
+	return func(o *GatewayOptions) {
+		o.ReloadInterval = interval
+	}
+}
+
+func GatewayShutdownTimeout(timeout time.Duration) GatewayOption {
+	return func(o *GatewayOptions) {
+		o.ShutdownTimeout = timeout
+	}
+}
+
+// GatewayAuthHandler 注册鉴权函数, 配置中policies.auth按名字引用
+func GatewayAuthHandler(name string, h func(w http.ResponseWriter, r *http.Request) error) GatewayOption {
+	return func(o *GatewayOptions) {
+		o.AuthHandlers[name] = h
+	}
+}
+
+func GatewayHttpOptions(opts ...HttpOption) GatewayOption {
+	return func(o *GatewayOptions) {
+		o.HttpOpts = append(o.HttpOpts, opts...)
+	}
+}
+
+// Gateway 按配置文件启动监听及路由, 配置变更时热加载
+// 路由表原子替换, 新增的监听启动, 删除的监听优雅关闭, 存量连接不受影响
+type Gateway struct {
+	path string
+	opts GatewayOptions
+
+	lock    sync.Mutex
+	raw     []byte
+	cfg     *GatewayConfig
+	servers map[string]*gatewayServer
+	// 未变更的路由复用handler, 保留限流及流会话状态
+	routes map[string]http.Handler
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+}
+
+type gatewayServer struct {
+	addr   string
+	srv    *http.Server
+	router atomic.Pointer[gatewayRouter]
+}
+
+type gatewayRouter struct {
+	// 按前缀长度倒序
+	routes []*gatewayRoute
+}
+
+type gatewayRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+// NewGateway 加载并校验配置
+func NewGateway(path string, opts ...GatewayOption) (*Gateway, error) {
+	g := &Gateway{
+		path:    path,
+		opts:    newGatewayOptions(opts...),
+		servers: make(map[string]*gatewayServer),
+		routes:  make(map[string]http.Handler),
+		doneCh:  make(chan struct{}),
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := g.parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	g.raw = raw
+	g.cfg = cfg
+	return g, nil
+}
+
+func (g *Gateway) parse(raw []byte) (*GatewayConfig, error) {
+	cfg, err := ParseGatewayConfig(raw, filepath.Ext(g.path))
+	if err != nil {
+		return nil, err
+	}
+
+	authNames := make(map[string]bool)
+	for name := range g.opts.AuthHandlers {
+		authNames[name] = true
+	}
+	if err := cfg.Validate(authNames); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Run 启动监听并阻塞, 直到Close; 监听失败(如端口被占用)时立即返回错误
+func (g *Gateway) Run() error {
+	g.lock.Lock()
+	err := g.apply(g.cfg)
+	g.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if g.opts.ReloadInterval > 0 {
+		go g.watch()
+	}
+	<-g.doneCh
+	return nil
+}
+
+func (g *Gateway) Close() {
+	g.closeOnce.Do(func() {
+		close(g.doneCh)
+
+		g.lock.Lock()
+		servers := g.servers
+		g.servers = make(map[string]*gatewayServer)
+		g.lock.Unlock()
+
+		// 各监听并行关闭, 总耗时不超过ShutdownTimeout
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func(s *gatewayServer) {
+				defer wg.Done()
+				g.shutdown(s)
+			}(s)
+		}
+		wg.Wait()
+	})
+}
+
+// Config 返回当前生效的配置
+func (g *Gateway) Config() *GatewayConfig {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.cfg
+}
+
+func (g *Gateway) watch() {
+	ticker := time.NewTicker(g.opts.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.doneCh:
+			return
+		case <-ticker.C:
+			if err := g.Reload(); err != nil {
+				logger.Errorf("[gate] gateway reload fail:%s", err)
+			}
+		}
+	}
+}
+
+// Reload 重新加载配置, 校验或监听失败时保留原配置
+func (g *Gateway) Reload() error {
+	raw, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if bytes.Equal(raw, g.raw) {
+		return nil
+	}
+
+	cfg, err := g.parse(raw)
+	if err != nil {
+		return err
+	}
+	if err := g.apply(cfg); err != nil {
+		return err
+	}
+	g.raw = raw
+	g.cfg = cfg
+	logger.Infof("[gate] gateway config reloaded path:%s", g.path)
+	return nil
+}
+
+// apply 需持有lock, 先同步绑定新增的监听, 失败时不做任何变更
+func (g *Gateway) apply(cfg *GatewayConfig) error {
+	added := make(map[string]*gatewayServer)
+	for _, l := range cfg.Listeners {
+		if _, ok := g.servers[l.Addr]; ok {
+			continue
+		}
+		s, err := g.listen(l.Addr)
+		if err != nil {
+			for _, s := range added {
+				s.srv.Close()
+			}
+			return err
+		}
+		added[l.Addr] = s
+	}
+	for addr, s := range added {
+		g.servers[addr] = s
+	}
+
+	routes := make(map[string]http.Handler)
+	for _, l := range cfg.Listeners {
+		router := &gatewayRouter{}
+		for _, rc := range cfg.Routes {
+			if rc.Listener != "" && rc.Listener != l.Name {
+				continue
+			}
+			key := routeKey(cfg, rc)
+			h, ok := routes[key]
+			if !ok {
+				if h, ok = g.routes[key]; !ok {
+					h = g.buildRoute(cfg, rc)
+				}
+				routes[key] = h
+			}
+			router.routes = append(router.routes, &gatewayRoute{prefix: rc.Prefix, handler: h})
+		}
+		sort.SliceStable(router.routes, func(i, j int) bool {
+			return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+		})
+
+		g.servers[l.Addr].router.Store(router)
+	}
+	g.routes = routes
+
+	for addr, s := range g.servers {
+		found := false
+		for _, l := range cfg.Listeners {
+			if l.Addr == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			go g.shutdown(s)
+			delete(g.servers, addr)
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) listen(addr string) (*gatewayServer, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen addr:%s fail:%w", addr, err)
+	}
+	s := &gatewayServer{addr: addr}
+	s.router.Store(&gatewayRouter{})
+	s.srv = &http.Server{Addr: addr, Handler: s}
+
+	logger.Infof("[gate] gateway listen addr:%s", addr)
+	go func() {
+		if err := s.srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("[gate] gateway serve addr:%s fail:%s", addr, err)
+		}
+	}()
+	return s, nil
+}
+
+func (g *Gateway) shutdown(s *gatewayServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.opts.ShutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		logger.Errorf("[gate] gateway shutdown addr:%s fail:%s", s.addr, err)
+	}
+	logger.Infof("[gate] gateway listener closed addr:%s", s.addr)
+}
+
+func (s *gatewayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range s.router.Load().routes {
+		if matchPrefix(r.URL.Path, route.prefix) {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	ErrorResponse(w, r, neterrors.NotFound("[gate] route not found url:%s", r.RequestURI))
+}
+
+// matchPrefix 按路径段匹配, /rpc/sso只匹配/rpc/sso及/rpc/sso/..., 不匹配/rpc/ssox
+func matchPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// routeKey 路由及其引用的上游、策略都未变更时key不变
+func routeKey(cfg *GatewayConfig, rc *RouteConfig) string {
+	b, _ := json.Marshal([]any{
+		rc.Prefix, rc.Handler, rc.Timeout, rc.Auth,
+		cfg.upstream(rc.Upstream),
+		rc.Mirror, cfg.mirrorUpstream(rc),
+		rc.Streams, rc.Transform, rc.Rewrite, rc.Aggregate,
+		cfg.rateLimit(rc.RateLimit),
+		cfg.cors(rc.Cors),
+	})
+	return string(b)
+}
+
+func (g *Gateway) buildRoute(cfg *GatewayConfig, rc *RouteConfig) http.Handler {
+	opts := append([]HttpOption{}, g.opts.HttpOpts...)
+	if u := cfg.upstream(rc.Upstream); u != nil {
+		addr := u.Addr
+		opts = append(opts, HttpTarget(func(service string) string {
+			return addr
+		}))
+		if u.Traffic != nil {
+			opts = append(opts, HttpTraffic("", u.Traffic))
+		}
+	}
+	if rc.Timeout > 0 {
+		opts = append(opts, HttpTimeout(time.Duration(rc.Timeout)))
+	}
+	if len(rc.Streams) > 0 {
+		opts = append(opts, HttpWebStreams(rc.Streams...))
+	}
+	if rc.Auth != "" {
+		opts = append(opts, HttpAuthHandler(g.opts.AuthHandlers[rc.Auth]))
+	}
+	// 转换在镜像之外, 影子服务收到与主服务相同的请求
+	if rc.Transform != nil {
+		opts = append(opts, HttpTransform(rc.Transform))
+	}
+	if u := cfg.mirrorUpstream(rc); u != nil {
+		addr := u.Addr
+		m := NewMirror(MirrorTarget(func(service string) string {
+			return addr
+		}), MirrorPercent(rc.Mirror.Percent), MirrorDiff(rc.Mirror.Diff))
+		opts = append(opts, HttpWrapHandler(m.Wrapper()))
+	}
+
+	var h http.Handler
+	switch rc.Handler {
+	case RouteHandlerGrpcWeb:
+		h = http.HandlerFunc(NewGrpcWebHandler(opts...).Handle)
+	case RouteHandlerStream:
+		h = http.HandlerFunc(NewStreamHandler(opts...).Handle)
+	case RouteHandlerAggregate:
+		h = http.HandlerFunc(NewAggregateHandler(rc.Aggregate, opts...).Handle)
+	default:
+		h = http.HandlerFunc(NewGrpcHandler(opts...).Handle)
+	}
+
+	if len(rc.Rewrite) > 0 {
+		h = RewritePaths(rc.Rewrite, h)
+	}
+	if rl := cfg.rateLimit(rc.RateLimit); rl != nil {
+		h = newRateLimiter(rl).wrap(h)
+	}
+	if cors := cfg.cors(rc.Cors); cors != nil {
+		h = newCorsPolicy(cors).wrap(h)
+	}
+	return h
+}
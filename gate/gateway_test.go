@@ -0,0 +1,222 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+const testGatewayYaml = `
+listeners:
+  - name: public
+    addr: %s
+upstreams:
+  - name: echo
+    addr: %s
+routes:
+  - prefix: %s
+    handler: grpc
+    upstream: echo
+    timeout: 3s
+    auth: token
+    ratelimit: api
+    cors: web
+policies:
+  auth: [token]
+  ratelimit:
+    - name: api
+      rate: 100
+      burst: 10
+  cors:
+    - name: web
+      allowOrigins: ["*"]
+      maxAge: 10m
+`
+
+func TestParseGatewayConfig(t *testing.T) {
+	cfg, err := ParseGatewayConfig([]byte(fmt.Sprintf(testGatewayYaml, ":8080", "127.0.0.1:10000", "/rpc/")), ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Routes[0].Timeout != Duration(time.Second*3) || cfg.Policies.Cors[0].MaxAge != Duration(time.Minute*10) {
+		t.Fatalf("duration parse fail %+v", cfg.Routes[0])
+	}
+	if err := cfg.Validate(map[string]bool{"token": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(nil); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Fatalf("unregistered auth should fail, err:%v", err)
+	}
+
+	js := `{"listeners":[{"name":"a","addr":":80"}],"routes":[{"prefix":"/rpc/","handler":"grpc","upstream":"none"}]}`
+	cfg, err = ParseGatewayConfig([]byte(js), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(nil); err == nil || !strings.Contains(err.Error(), "upstream") {
+		t.Fatalf("unknown upstream should fail, err:%v", err)
+	}
+
+	if _, err := ParseGatewayConfig([]byte(`{"listener":[]}`), ".json"); err == nil {
+		t.Fatal("unknown field should fail")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(&RateLimitConfig{Name: "api", Rate: 1, Burst: 2})
+	now := time.Now()
+	if !l.allow("a", now) || !l.allow("a", now) {
+		t.Fatal("burst should be allowed")
+	}
+	if l.allow("a", now) {
+		t.Fatal("should be limited")
+	}
+	if !l.allow("b", now) {
+		t.Fatal("key b should not be limited")
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Fatal("token should be refilled")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func gatewayCall(addr, path string) (int, string, error) {
+	req, _ := http.NewRequest("POST", "http://"+addr+path, strings.NewReader(`{"name":"gw"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b), nil
+}
+
+func TestGatewayReload(t *testing.T) {
+	upstream := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+	})
+	addr := freeAddr(t)
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(testGatewayYaml, addr, upstream, "/rpc/")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewGateway(path,
+		GatewayReloadInterval(time.Millisecond*50),
+		GatewayShutdownTimeout(time.Second),
+		GatewayAuthHandler("token", func(w http.ResponseWriter, r *http.Request) error {
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Run()
+	defer g.Close()
+	time.Sleep(time.Millisecond * 200)
+
+	status, body, err := gatewayCall(addr, "/rpc/echo/EchoService.Hello")
+	if err != nil || status != 200 || !strings.Contains(body, "hello gw") {
+		t.Fatalf("call fail status:%d body:%s err:%v", status, body, err)
+	}
+
+	// 非法配置不生效
+	os.WriteFile(path, []byte("listeners: []"), 0644)
+	time.Sleep(time.Millisecond * 200)
+	if status, _, _ := gatewayCall(addr, "/rpc/echo/EchoService.Hello"); status != 200 {
+		t.Fatalf("invalid config should be ignored, status:%d", status)
+	}
+
+	// 修改路由前缀后旧路由下线
+	os.WriteFile(path, []byte(fmt.Sprintf(testGatewayYaml, addr, upstream, "/api/")), 0644)
+	time.Sleep(time.Millisecond * 200)
+	if status, _, _ := gatewayCall(addr, "/rpc/echo/EchoService.Hello"); status != 404 {
+		t.Fatalf("old route should be removed, status:%d", status)
+	}
+	if status, body, _ := gatewayCall(addr, "/api/echo/EchoService.Hello"); status != 200 {
+		t.Fatalf("new route fail status:%d body:%s", status, body)
+	}
+}
+
+func TestGatewayMatchPrefix(t *testing.T) {
+	cases := []struct {
+		path, prefix string
+		match        bool
+	}{
+		{"/rpc/sso", "/rpc/sso", true},
+		{"/rpc/sso/AuthService.Login", "/rpc/sso", true},
+		{"/rpc/ssox/AuthService.Login", "/rpc/sso", false},
+		{"/rpc/ssox", "/rpc/", true},
+		{"/rpc", "/rpc/", false},
+	}
+	for _, c := range cases {
+		if matchPrefix(c.path, c.prefix) != c.match {
+			t.Fatalf("path:%s prefix:%s should match:%v", c.path, c.prefix, c.match)
+		}
+	}
+}
+
+func TestGatewayListenFail(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	addr := freeAddr(t)
+
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(testGatewayYaml, busy.Addr().String(), "127.0.0.1:10000", "/rpc/")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := []GatewayOption{GatewayReloadInterval(0), GatewayAuthHandler("token", func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})}
+	g, err := NewGateway(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err == nil || !strings.Contains(err.Error(), busy.Addr().String()) {
+		t.Fatalf("busy addr should fail, err:%v", err)
+	}
+
+	// 热加载时监听失败保留原配置
+	os.WriteFile(path, []byte(fmt.Sprintf(testGatewayYaml, addr, "127.0.0.1:10000", "/rpc/")), 0644)
+	g, err = NewGateway(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Run()
+	defer g.Close()
+	time.Sleep(time.Millisecond * 100)
+	os.WriteFile(path, []byte(fmt.Sprintf(testGatewayYaml, busy.Addr().String(), "127.0.0.1:10000", "/rpc/")), 0644)
+	if err := g.Reload(); err == nil {
+		t.Fatal("reload with busy addr should fail")
+	}
+	if g.Config().Listeners[0].Addr != addr {
+		t.Fatalf("old config should be kept %s", g.Config().Listeners[0].Addr)
+	}
+	if _, _, err := gatewayCall(addr, "/rpc/echo/EchoService.Hello"); err != nil {
+		t.Fatalf("old listener should keep serving %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 路由的处理器类型
+const (
+	RouteHandlerGrpc      = "grpc"
+	RouteHandlerGrpcWeb   = "grpcweb"
+	RouteHandlerStream    = "stream"
+	RouteHandlerAggregate = "aggregate"
+)
+
+// 限流的分组方式
+const (
+	RateLimitKeyGlobal = "global"
+	RateLimitKeyIp     = "ip"
+	// header:<Name>
+	RateLimitKeyHeader = "header:"
+)
+
+// Duration 配置中的时长, 格式同time.ParseDuration, 如"5s"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// GatewayConfig 网关声明式配置, 支持json及yaml
+type GatewayConfig struct {
+	Listeners []*ListenerConfig `json:"listeners" yaml:"listeners"`
+	Upstreams []*UpstreamConfig `json:"upstreams" yaml:"upstreams"`
+	Routes    []*RouteConfig    `json:"routes" yaml:"routes"`
+	Policies  PoliciesConfig    `json:"policies" yaml:"policies"`
+}
+
+type ListenerConfig struct {
+	Name string `json:"name" yaml:"name"`
+	Addr string `json:"addr" yaml:"addr"`
+}
+
+type UpstreamConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// grpc地址
+	Addr string `json:"addr" yaml:"addr"`
+	// 灰度流量划分, 未命中时使用Addr
+	Traffic *TrafficPolicy `json:"traffic,omitempty" yaml:"traffic,omitempty"`
+}
+
+type RouteConfig struct {
+	// 为空时挂到所有监听
+	Listener string `json:"listener,omitempty" yaml:"listener,omitempty"`
+	// 路径前缀, 按路径段最长匹配, /rpc/sso不匹配/rpc/ssox
+	Prefix  string `json:"prefix" yaml:"prefix"`
+	Handler string `json:"handler" yaml:"handler"`
+	// 为空时按服务名:HttpGrpcPort转发
+	Upstream  string   `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	Timeout   Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Auth      string   `json:"auth,omitempty" yaml:"auth,omitempty"`
+	RateLimit string   `json:"ratelimit,omitempty" yaml:"ratelimit,omitempty"`
+	Cors      string   `json:"cors,omitempty" yaml:"cors,omitempty"`
+	// 流量镜像, 仅支持grpc
+	Mirror *MirrorConfig `json:"mirror,omitempty" yaml:"mirror,omitempty"`
+	// gRPC-Web服务端流接口, 服务名/接口名, 仅支持grpcweb
+	Streams []string `json:"streams,omitempty" yaml:"streams,omitempty"`
+	// 请求及响应转换, 仅支持grpc
+	Transform *TransformConfig `json:"transform,omitempty" yaml:"transform,omitempty"`
+	// 旧路径 -> 新路径, key以/结尾时按前缀替换, 按改写前的路径匹配路由
+	Rewrite map[string]string `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	// handler为aggregate时的组合调用
+	Aggregate *AggregateConfig `json:"aggregate,omitempty" yaml:"aggregate,omitempty"`
+}
+
+type MirrorConfig struct {
+	// 影子服务
+	Upstream string `json:"upstream" yaml:"upstream"`
+	// 镜像比例, 0~100
+	Percent float64 `json:"percent" yaml:"percent"`
+	// 对比响应并记录不一致
+	Diff bool `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+type PoliciesConfig struct {
+	// 鉴权函数在代码中通过GatewayAuthHandler注册, 配置中按名字引用
+	Auth      []string           `json:"auth" yaml:"auth"`
+	RateLimit []*RateLimitConfig `json:"ratelimit" yaml:"ratelimit"`
+	Cors      []*CorsConfig      `json:"cors" yaml:"cors"`
+}
+
+type RateLimitConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// 每秒请求数
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+	// global、ip或header:<Name>, 缺省ip
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+}
+
+type CorsConfig struct {
+	Name             string   `json:"name" yaml:"name"`
+	AllowOrigins     []string `json:"allowOrigins" yaml:"allowOrigins"`
+	AllowMethods     []string `json:"allowMethods" yaml:"allowMethods"`
+	AllowHeaders     []string `json:"allowHeaders" yaml:"allowHeaders"`
+	ExposeHeaders    []string `json:"exposeHeaders" yaml:"exposeHeaders"`
+	AllowCredentials bool     `json:"allowCredentials" yaml:"allowCredentials"`
+	MaxAge           Duration `json:"maxAge" yaml:"maxAge"`
+}
+
+// LoadGatewayConfig 按扩展名解析json或yaml
+func LoadGatewayConfig(path string) (*GatewayConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGatewayConfig(b, filepath.Ext(path))
+}
+
+func ParseGatewayConfig(b []byte, ext string) (*GatewayConfig, error) {
+	cfg := &GatewayConfig{}
+	switch strings.ToLower(ext) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("config format:%s not support", ext)
+	}
+	return cfg, nil
+}
+
+// Validate 校验配置的完整性, authNames为代码中已注册的鉴权函数
+func (c *GatewayConfig) Validate(authNames map[string]bool) error {
+	if len(c.Listeners) == 0 {
+		return fmt.Errorf("listeners is empty")
+	}
+	listeners := make(map[string]bool)
+	addrs := make(map[string]bool)
+	for _, l := range c.Listeners {
+		if l.Name == "" || l.Addr == "" {
+			return fmt.Errorf("listener name and addr are required")
+		}
+		if listeners[l.Name] {
+			return fmt.Errorf("listener %s duplicated", l.Name)
+		}
+		if addrs[l.Addr] {
+			return fmt.Errorf("listener %s: addr %s duplicated", l.Name, l.Addr)
+		}
+		listeners[l.Name] = true
+		addrs[l.Addr] = true
+	}
+
+	upstreams := make(map[string]bool)
+	for _, u := range c.Upstreams {
+		if u.Name == "" || u.Addr == "" {
+			return fmt.Errorf("upstream name and addr are required")
+		}
+		if upstreams[u.Name] {
+			return fmt.Errorf("upstream %s duplicated", u.Name)
+		}
+		if u.Traffic != nil {
+			if err := u.Traffic.Validate(); err != nil {
+				return fmt.Errorf("upstream %s: %w", u.Name, err)
+			}
+		}
+		upstreams[u.Name] = true
+	}
+
+	auths := make(map[string]bool)
+	for _, name := range c.Policies.Auth {
+		if !authNames[name] {
+			return fmt.Errorf("auth policy %s not registered", name)
+		}
+		auths[name] = true
+	}
+	ratelimits := make(map[string]bool)
+	for _, rl := range c.Policies.RateLimit {
+		if rl.Name == "" || rl.Rate <= 0 || rl.Burst <= 0 {
+			return fmt.Errorf("ratelimit %s: name, rate and burst are required", rl.Name)
+		}
+		if rl.Key != "" && rl.Key != RateLimitKeyGlobal && rl.Key != RateLimitKeyIp &&
+			!(strings.HasPrefix(rl.Key, RateLimitKeyHeader) && len(rl.Key) > len(RateLimitKeyHeader)) {
+			return fmt.Errorf("ratelimit %s: key %s not support", rl.Name, rl.Key)
+		}
+		ratelimits[rl.Name] = true
+	}
+	corses := make(map[string]bool)
+	for _, cors := range c.Policies.Cors {
+		if cors.Name == "" || len(cors.AllowOrigins) == 0 {
+			return fmt.Errorf("cors %s: name and allowOrigins are required", cors.Name)
+		}
+		corses[cors.Name] = true
+	}
+
+	routes := make(map[string]bool)
+	for _, r := range c.Routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("route prefix %s should start with /", r.Prefix)
+		}
+		key := r.Listener + " " + r.Prefix
+		if routes[key] {
+			return fmt.Errorf("route %s duplicated", r.Prefix)
+		}
+		routes[key] = true
+
+		switch r.Handler {
+		case RouteHandlerGrpc, RouteHandlerGrpcWeb, RouteHandlerStream, RouteHandlerAggregate:
+		default:
+			return fmt.Errorf("route %s: handler %s not support", r.Prefix, r.Handler)
+		}
+		if r.Listener != "" && !listeners[r.Listener] {
+			return fmt.Errorf("route %s: listener %s not found", r.Prefix, r.Listener)
+		}
+		if r.Upstream != "" && !upstreams[r.Upstream] {
+			return fmt.Errorf("route %s: upstream %s not found", r.Prefix, r.Upstream)
+		}
+		if r.Auth != "" && !auths[r.Auth] {
+			return fmt.Errorf("route %s: auth %s not found", r.Prefix, r.Auth)
+		}
+		if r.RateLimit != "" && !ratelimits[r.RateLimit] {
+			return fmt.Errorf("route %s: ratelimit %s not found", r.Prefix, r.RateLimit)
+		}
+		if r.Cors != "" && !corses[r.Cors] {
+			return fmt.Errorf("route %s: cors %s not found", r.Prefix, r.Cors)
+		}
+		if r.Timeout < 0 {
+			return fmt.Errorf("route %s: timeout should not be negative", r.Prefix)
+		}
+		if m := r.Mirror; m != nil {
+			if r.Handler != RouteHandlerGrpc {
+				return fmt.Errorf("route %s: mirror only support handler %s", r.Prefix, RouteHandlerGrpc)
+			}
+			if !upstreams[m.Upstream] {
+				return fmt.Errorf("route %s: mirror upstream %s not found", r.Prefix, m.Upstream)
+			}
+			if m.Percent <= 0 || m.Percent > 100 {
+				return fmt.Errorf("route %s: mirror percent should be in (0, 100]", r.Prefix)
+			}
+		}
+		if len(r.Streams) > 0 && r.Handler != RouteHandlerGrpcWeb {
+			return fmt.Errorf("route %s: streams only support handler %s", r.Prefix, RouteHandlerGrpcWeb)
+		}
+		if r.Transform != nil {
+			if r.Handler != RouteHandlerGrpc {
+				return fmt.Errorf("route %s: transform only support handler %s", r.Prefix, RouteHandlerGrpc)
+			}
+			if err := r.Transform.Validate(); err != nil {
+				return fmt.Errorf("route %s: %w", r.Prefix, err)
+			}
+		}
+		if (r.Handler == RouteHandlerAggregate) != (r.Aggregate != nil) {
+			return fmt.Errorf("route %s: aggregate is required only for handler %s", r.Prefix, RouteHandlerAggregate)
+		}
+		if r.Aggregate != nil {
+			if err := r.Aggregate.Validate(); err != nil {
+				return fmt.Errorf("route %s: %w", r.Prefix, err)
+			}
+		}
+		for from, to := range r.Rewrite {
+			if !strings.HasPrefix(from, "/") || !strings.HasPrefix(to, "/") {
+				return fmt.Errorf("route %s: rewrite path should start with /", r.Prefix)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *GatewayConfig) upstream(name string) *UpstreamConfig {
+	for _, u := range c.Upstreams {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+func (c *GatewayConfig) mirrorUpstream(rc *RouteConfig) *UpstreamConfig {
+	if rc.Mirror == nil {
+		return nil
+	}
+	return c.upstream(rc.Mirror.Upstream)
+}
+
+func (c *GatewayConfig) rateLimit(name string) *RateLimitConfig {
+	for _, rl := range c.Policies.RateLimit {
+		if rl.Name == name {
+			return rl
+		}
+	}
+	return nil
+}
+
+func (c *GatewayConfig) cors(name string) *CorsConfig {
+	for _, cors := range c.Policies.Cors {
+		if cors.Name == name {
+			return cors
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package gate
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+)
+
+// corsPolicy 处理跨域预检及响应头
+type corsPolicy struct {
+	cfg     *CorsConfig
+	origins map[string]bool
+	any     bool
+}
+
+func newCorsPolicy(cfg *CorsConfig) *corsPolicy {
+	p := &corsPolicy{cfg: cfg, origins: make(map[string]bool)}
+	for _, o := range cfg.AllowOrigins {
+		if o == "*" {
+			p.any = true
+		}
+		p.origins[o] = true
+	}
+	return p
+}
+
+func (p *corsPolicy) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(p.any || p.origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hdr := w.Header()
+		if p.any && !p.cfg.AllowCredentials {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			hdr.Add("Vary", "Origin")
+		}
+		if p.cfg.AllowCredentials {
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(p.cfg.ExposeHeaders) > 0 {
+			hdr.Set("Access-Control-Expose-Headers", strings.Join(p.cfg.ExposeHeaders, ", "))
+		}
+
+		// 预检请求直接返回
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			methods := p.cfg.AllowMethods
+			if len(methods) == 0 {
+				methods = []string{http.MethodGet, http.MethodPost}
+			}
+			hdr.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(p.cfg.AllowHeaders) > 0 {
+				hdr.Set("Access-Control-Allow-Headers", strings.Join(p.cfg.AllowHeaders, ", "))
+			} else if reqHdr := r.Header.Get("Access-Control-Request-Headers"); reqHdr != "" {
+				hdr.Set("Access-Control-Allow-Headers", reqHdr)
+			}
+			if p.cfg.MaxAge > 0 {
+				hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(p.cfg.MaxAge).Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter 令牌桶限流, 按key分组
+type rateLimiter struct {
+	cfg     *RateLimitConfig
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	// 上次清理空闲桶的时间
+	pruneAt time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg *RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		cfg:     cfg,
+		buckets: make(map[string]*tokenBucket),
+		pruneAt: time.Now(),
+	}
+}
+
+func (l *rateLimiter) key(r *http.Request) string {
+	switch {
+	case l.cfg.Key == RateLimitKeyGlobal:
+		return ""
+	case strings.HasPrefix(l.cfg.Key, RateLimitKeyHeader):
+		return r.Header.Get(strings.TrimPrefix(l.cfg.Key, RateLimitKeyHeader))
+	default:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	burst := float64(l.cfg.Burst)
+	if now.Sub(l.pruneAt) > time.Minute {
+		// 桶已回满的可直接删除
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.cfg.Rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruneAt = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.cfg.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(l.key(r), time.Now()) {
+			ErrorResponse(w, r, &neterrors.NetError{
+				Code:   -1,
+				Status: http.StatusTooManyRequests,
+				Msg:    "[gate] too many requests",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/vison888/go-vkit/errorsx/neterrors"
@@ -49,6 +48,7 @@ func (h *GrpcHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stripHeaders(r, h.opts.TrustedHeaders)
 	// 鉴权
 	if h.opts.AuthHandler != nil {
 		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
@@ -90,6 +90,7 @@ func (h *GrpcHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		r:           r,
 		service:     service,
 		method:      method,
+		endpoint:    endpoint,
 		contentType: readCt,
 		body:        nil,
 		hasRead:     false,
@@ -102,7 +103,9 @@ func (h *GrpcHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		content:  nil,
 	}
 
-	fullCtx := requestToContext(context.Background(), md, r)
+	// 客户端断开时取消转发
+	fullCtx, cancel := withTimeout(requestToContext(r.Context(), md, r), r, h.opts.timeout(service, endpoint))
+	defer cancel()
 	// 主逻辑
 	fn := func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
 		reqBytes, _, err := request.Read()
@@ -111,7 +114,7 @@ func (h *GrpcHandler) Handle(w http.ResponseWriter, r *http.Request) {
 			return neterrors.BadRequest(errorStr)
 		}
 
-		target := fmt.Sprintf("%s:%d", service, h.opts.GrpcPort)
+		target := h.opts.route(ctx, service, r)
 		jsonRaw, netErr := grpcclient.InvokeByGate(ctx, target, service, endpoint, reqBytes)
 		if netErr != nil {
 			logger.Infof("[gate] InvokeWithJson response netErr:%s", netErr)
@@ -128,25 +131,6 @@ func (h *GrpcHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return nil
 	}
 	// 拦截器
-	for i := len(h.opts.HdlrWrappers); i > 0; i-- {
-		fn = h.opts.HdlrWrappers[i-1](fn)
-	}
-
-	if appErr := fn(fullCtx, request, response); appErr != nil {
-		switch verr := appErr.(type) {
-		case *neterrors.NetError:
-			ErrorResponse(w, r, verr)
-		default:
-			ErrorResponse(w, r, neterrors.BadRequest(verr.Error()))
-		}
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(200)
-	w.Header().Set("Content-Length", strconv.Itoa(len(response.content)))
-	_, err := w.Write(response.content)
-	if err != nil {
-		logger.Errorf("[gate] response fail url:%v respBytes:%s", r.RequestURI, string(response.content))
-	}
+	fn = h.opts.wrap(fn)
+	writeResult(fullCtx, w, r, response, fn(fullCtx, request, response))
 }
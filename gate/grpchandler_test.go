@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/vison888/go-vkit/errorsx"
 	"github.com/vison888/go-vkit/errorsx/neterrors"
 	"github.com/vison888/go-vkit/grpcclient"
 	"github.com/vison888/go-vkit/grpcserver"
@@ -333,3 +336,83 @@ func TestTraceLog(t *testing.T) {
 		})
 	}
 }
+
+func TestGrpcDeadline(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+		grpcserver.Handle(svr, "EchoService.Deadline", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			if d, ok := ctx.Deadline(); ok {
+				resp.Msg = fmt.Sprintf("%d", time.Until(d).Milliseconds())
+			}
+			return nil
+		})
+	})
+	h := NewGrpcHandler(HttpTarget(func(service string) string { return addr }), HttpTimeout(time.Second*10), HttpRouteTimeout("echo/EchoService.Deadline", time.Second*2))
+
+	call := func(header, timeout string) int64 {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Deadline", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		if timeout != "" {
+			r.Header.Set(header, timeout)
+		}
+		w := httptest.NewRecorder()
+		h.Handle(w, r)
+		resp := &echoResp{}
+		if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+			t.Fatalf("unmarshal %s fail:%s", w.Body.String(), err)
+		}
+		ms, _ := strconv.ParseInt(resp.Msg, 10, 64)
+		return ms
+	}
+
+	// 接口级超时优先于全局超时
+	if ms := call("", ""); ms <= 1000 || ms > 2000 {
+		t.Fatalf("route timeout not applied, remaining:%dms", ms)
+	}
+	// 客户端超时更小时生效, timeout头纯数字为秒
+	for header, timeout := range map[string]string{"Timeout-Ms": "500", "Timeout": "500ms"} {
+		if ms := call(header, timeout); ms <= 0 || ms > 500 {
+			t.Fatalf("header %s:%s not applied, remaining:%dms", header, timeout, ms)
+		}
+	}
+	if ms := call("Timeout", "1"); ms <= 500 || ms > 1000 {
+		t.Fatalf("legacy timeout in seconds not applied, remaining:%dms", ms)
+	}
+
+	// 客户端取消后不再等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{}`)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code == http.StatusOK {
+		t.Fatalf("canceled request should fail, body:%s", w.Body.String())
+	}
+}
+
+func TestErrorResponseLocalize(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			return errorsx.PARAM_ERR
+		})
+	})
+	h := NewGrpcHandler(HttpTarget(func(service string) string {
+		return addr
+	}))
+
+	// 按请求的accept-language翻译上游返回的错误码
+	for lang, msg := range map[string]string{"en-US,en;q=0.9": "Invalid parameter", "zh-CN": "参数错误"} {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		h.Handle(w, r)
+		if !strings.Contains(w.Body.String(), msg) {
+			t.Fatalf("lang:%s msg not localized %s", lang, w.Body.String())
+		}
+	}
+}
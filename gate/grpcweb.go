@@ -0,0 +1,256 @@
+package gate
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"google.golang.org/grpc/codes"
+)
+
+// 浏览器协议
+const (
+	webProtocolGrpcWeb = iota
+	webProtocolGrpcWebText
+	webProtocolConnect
+	webProtocolConnectStream
+)
+
+const (
+	// grpc-web帧标志
+	grpcWebFlagTrailer = 0x80
+	// connect流式帧标志
+	connectFlagCompressed = 0x01
+	connectFlagEndStream  = 0x02
+)
+
+// webProtocol 请求使用的协议及消息编码
+type webProtocol struct {
+	kind int
+	// proto或json
+	subtype string
+	// 原始content-type, 响应时原样返回
+	contentType string
+}
+
+func parseWebProtocol(contentType string) (*webProtocol, error) {
+	ct := contentType
+	if index := strings.Index(ct, ";"); index != -1 {
+		ct = ct[:index]
+	}
+	ct = strings.ToLower(strings.TrimSpace(ct))
+
+	p := &webProtocol{subtype: "proto", contentType: ct}
+	prefix := ""
+	switch {
+	case strings.HasPrefix(ct, "application/grpc-web-text"):
+		p.kind, prefix = webProtocolGrpcWebText, "application/grpc-web-text"
+	case strings.HasPrefix(ct, "application/grpc-web"):
+		p.kind, prefix = webProtocolGrpcWeb, "application/grpc-web"
+	case strings.HasPrefix(ct, "application/connect+"):
+		p.kind, prefix = webProtocolConnectStream, "application/connect"
+	case ct == "application/proto" || ct == "application/json":
+		p.kind, p.subtype = webProtocolConnect, strings.TrimPrefix(ct, "application/")
+		return p, nil
+	default:
+		return nil, fmt.Errorf("content-type:%s not support", contentType)
+	}
+
+	if sub := strings.TrimPrefix(ct, prefix); sub != "" {
+		p.subtype = strings.TrimPrefix(sub, "+")
+	}
+	if p.subtype != "proto" && p.subtype != "json" {
+		return nil, fmt.Errorf("content-type:%s not support", contentType)
+	}
+	// 未带编码的grpc-web按proto返回
+	if p.contentType == prefix && p.kind != webProtocolConnectStream {
+		p.contentType = prefix + "+proto"
+	}
+	return p, nil
+}
+
+// upstreamContentType 转发grpc时使用的content-type
+func (p *webProtocol) upstreamContentType() string {
+	return "application/" + p.subtype
+}
+
+func (p *webProtocol) streaming() bool {
+	return p.kind != webProtocolConnect
+}
+
+// timeout 解析grpc-timeout或connect-timeout-ms
+func (p *webProtocol) timeout(r *http.Request) time.Duration {
+	if p.kind == webProtocolConnect || p.kind == webProtocolConnectStream {
+		if ms, err := strconv.ParseInt(r.Header.Get("Connect-Timeout-Ms"), 10, 64); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		return 0
+	}
+
+	v := r.Header.Get("Grpc-Timeout")
+	if len(v) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0
+	}
+	return time.Duration(n) * unit
+}
+
+// readMessage 读取请求中的唯一消息
+func (p *webProtocol) readMessage(body io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if p.kind == webProtocolConnect {
+		return b, nil
+	}
+	if p.kind == webProtocolGrpcWebText {
+		b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 5字节帧头: 1字节标志 + 4字节长度
+	if len(b) < 5 {
+		return nil, fmt.Errorf("invalid frame length:%d", len(b))
+	}
+	if b[0]&connectFlagCompressed != 0 {
+		return nil, fmt.Errorf("compressed frame not support")
+	}
+	size := binary.BigEndian.Uint32(b[1:5])
+	if uint32(len(b)-5) < size {
+		return nil, fmt.Errorf("invalid frame length:%d", size)
+	}
+	return b[5 : 5+size], nil
+}
+
+func encodeWebFrame(flag byte, msg []byte) []byte {
+	b := make([]byte, 5+len(msg))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(msg)))
+	copy(b[5:], msg)
+	return b
+}
+
+// writeFrame 写入一条消息, text模式下每帧单独base64
+func (p *webProtocol) writeFrame(w io.Writer, flag byte, msg []byte) error {
+	b := encodeWebFrame(flag, msg)
+	if p.kind == webProtocolGrpcWebText {
+		b = []byte(base64.StdEncoding.EncodeToString(b))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// writeEnd 写入结束帧, grpc-web为trailer帧, connect为end-stream帧
+func (p *webProtocol) writeEnd(w io.Writer, netErr *neterrors.NetError) error {
+	if p.kind == webProtocolConnectStream {
+		end := map[string]any{}
+		if netErr != nil {
+			end["error"] = connectError(netErr)
+			end["metadata"] = map[string][]string{
+				"x-error-code": {strconv.Itoa(int(netErr.Code))},
+			}
+		}
+		b, _ := json.Marshal(end)
+		return p.writeFrame(w, connectFlagEndStream, b)
+	}
+
+	hdr := grpcStatusHeaders(netErr)
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k + ": " + hdr[k] + "\r\n")
+	}
+	return p.writeFrame(w, grpcWebFlagTrailer, []byte(sb.String()))
+}
+
+func grpcStatusHeaders(netErr *neterrors.NetError) map[string]string {
+	if netErr == nil {
+		return map[string]string{"grpc-status": "0"}
+	}
+	return map[string]string{
+		"grpc-status":  strconv.Itoa(int(grpcCode(netErr))),
+		"grpc-message": percentEncode(netErr.Msg),
+		"x-error-code": strconv.Itoa(int(netErr.Code)),
+	}
+}
+
+// grpcCode 将NetError的http状态码映射为grpc状态码, 业务错误码见x-error-code
+func grpcCode(netErr *neterrors.NetError) codes.Code {
+	return neterrors.GRPCCode(netErr.Status)
+}
+
+var connectCodes = map[codes.Code]struct {
+	name   string
+	status int
+}{
+	codes.Canceled:           {"canceled", 499},
+	codes.Unknown:            {"unknown", http.StatusInternalServerError},
+	codes.InvalidArgument:    {"invalid_argument", http.StatusBadRequest},
+	codes.DeadlineExceeded:   {"deadline_exceeded", http.StatusGatewayTimeout},
+	codes.NotFound:           {"not_found", http.StatusNotFound},
+	codes.AlreadyExists:      {"already_exists", http.StatusConflict},
+	codes.PermissionDenied:   {"permission_denied", http.StatusForbidden},
+	codes.ResourceExhausted:  {"resource_exhausted", http.StatusTooManyRequests},
+	codes.FailedPrecondition: {"failed_precondition", http.StatusBadRequest},
+	codes.Aborted:            {"aborted", http.StatusConflict},
+	codes.OutOfRange:         {"out_of_range", http.StatusBadRequest},
+	codes.Unimplemented:      {"unimplemented", http.StatusNotImplemented},
+	codes.Internal:           {"internal", http.StatusInternalServerError},
+	codes.Unavailable:        {"unavailable", http.StatusServiceUnavailable},
+	codes.DataLoss:           {"data_loss", http.StatusInternalServerError},
+	codes.Unauthenticated:    {"unauthenticated", http.StatusUnauthorized},
+}
+
+// connectError connect协议的错误体
+func connectError(netErr *neterrors.NetError) map[string]any {
+	return map[string]any{
+		"code":    connectCodes[grpcCode(netErr)].name,
+		"message": netErr.Msg,
+		"details": []any{},
+	}
+}
+
+// percentEncode grpc-message按规范做百分号编码
+func percentEncode(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
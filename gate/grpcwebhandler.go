@@ -0,0 +1,249 @@
+package gate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vison888/go-vkit/codec"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcclient"
+	"github.com/vison888/go-vkit/grpcx"
+	"github.com/vison888/go-vkit/logger"
+	meta "github.com/vison888/go-vkit/metadata"
+)
+
+var (
+	DefaultWebMaxMessageSize = 1024 * 1024 * 16
+)
+
+// GrpcWebHandler 将gRPC-Web(二进制及text)和Connect协议转为grpc调用
+// 请求路径为/{package}.{Struct}/{Method}, package的最后一段作为服务名
+// Connect流式请求及HttpWebStreams设置的gRPC-Web接口按服务端流转发, 其余按一元调用转发
+// 不处理跨域预检, 浏览器跨域访问时需在外层配置cors策略, 未处理的OPTIONS请求返回405
+type GrpcWebHandler struct {
+	opts HttpOptions
+}
+
+func NewGrpcWebHandler(opts ...HttpOption) *GrpcWebHandler {
+	return &GrpcWebHandler{
+		opts: newHttpOptions(opts...),
+	}
+}
+
+func (h *GrpcWebHandler) Init(opts ...HttpOption) {
+	for _, o := range opts {
+		o(&h.opts)
+	}
+}
+
+func (h *GrpcWebHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if re := recover(); re != nil {
+			if h.opts.ErrHandler != nil {
+				h.opts.ErrHandler(w, r, re)
+			}
+		}
+	}()
+
+	method := strings.ToUpper(r.Method)
+	if method != "POST" {
+		errorStr := fmt.Sprintf("[gate] req method:%s not support url:%s", method, r.RequestURI)
+		ErrorResponse(w, r, neterrors.MethodNotAllowed(errorStr))
+		return
+	}
+
+	p, err := parseWebProtocol(r.Header.Get("Content-Type"))
+	if err != nil {
+		ErrorResponse(w, r, neterrors.BadRequest("[gate] %s", err.Error()))
+		return
+	}
+
+	stripHeaders(r, h.opts.TrustedHeaders)
+	// 鉴权
+	if h.opts.AuthHandler != nil {
+		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
+			h.writeError(w, r, p, grpcclient.ToNetError(cerr))
+			return
+		}
+	}
+
+	service, endpoint, err := webServiceMethod(r.URL.Path)
+	if err != nil {
+		h.writeError(w, r, p, neterrors.NotFound("[gate] %s", err.Error()).(*neterrors.NetError))
+		return
+	}
+
+	md := meta.Metadata{}
+	md["x-content-type"] = p.upstreamContentType()
+
+	request := &HttpRequest{
+		uri:         r.RequestURI,
+		r:           r,
+		service:     service,
+		method:      method,
+		endpoint:    endpoint,
+		contentType: p.contentType,
+		body:        nil,
+		hasRead:     false,
+	}
+
+	response := &HttpResponse{
+		w:        w,
+		header:   nil,
+		hasWrite: false,
+		content:  nil,
+	}
+
+	timeout := h.opts.timeout(service, endpoint)
+	if v := p.timeout(r); v > 0 && (timeout <= 0 || v < timeout) {
+		timeout = v
+	}
+	ctx, cancel := withTimeout(requestToContext(r.Context(), md, r), r, timeout)
+	defer cancel()
+
+	// 先解出消息, 拦截器可通过Read取得请求体
+	body, err := p.readMessage(http.MaxBytesReader(w, r.Body, int64(DefaultWebMaxMessageSize)))
+	if err != nil {
+		h.writeError(w, r, p, neterrors.BadRequest("[gate] read body fail %s url:%s", err.Error(), r.RequestURI).(*neterrors.NetError))
+		return
+	}
+	request.SetBody(body)
+
+	// connect按content-type区分, gRPC-Web按接口设置区分
+	serverStream := p.kind == webProtocolConnectStream || (p.streaming() && h.opts.webStream(service, endpoint))
+
+	// 主逻辑
+	fn := func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+		body, _, _ := req.Read()
+
+		target := h.opts.route(ctx, service, r)
+		if serverStream {
+			return h.stream(ctx, target, service, endpoint, p, body, resp)
+		}
+
+		respBytes, netErr := grpcclient.RawByGate(ctx, target, service, endpoint, body)
+		if netErr != nil {
+			logger.Infof("[gate] RawByGate response netErr:%s", netErr)
+			return netErr
+		}
+		resp.content = respBytes
+		return nil
+	}
+	// 拦截器
+	fn = h.opts.wrap(fn)
+
+	appErr := fn(ctx, request, response)
+	if p.streaming() {
+		// 流式响应已写出, 状态放在结束帧
+		if !response.hasWrite {
+			h.writeHeader(w, p)
+			// 一元调用或拦截器直接返回的结果, 如缓存命中、幂等重放
+			if appErr == nil && response.content != nil {
+				if err := p.writeFrame(w, 0, response.content); err != nil {
+					logger.Errorf("[gate] write frame fail url:%s err:%s", r.RequestURI, err)
+				}
+			}
+		}
+		if err := p.writeEnd(w, grpcclient.ToNetError(appErr)); err != nil {
+			logger.Errorf("[gate] write end frame fail url:%s err:%s", r.RequestURI, err)
+		}
+		return
+	}
+
+	if appErr != nil {
+		h.writeError(w, r, p, grpcclient.ToNetError(appErr))
+		return
+	}
+
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(response.content)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(response.content); err != nil {
+		logger.Errorf("[gate] response fail url:%v err:%s", r.RequestURI, err)
+	}
+}
+
+// stream 发送唯一请求后逐条写出服务端消息, resp.content保持为空
+func (h *GrpcWebHandler) stream(ctx context.Context, target, service, endpoint string, p *webProtocol, body []byte, resp *HttpResponse) error {
+	stream, netErr := grpcclient.StreamByGate(ctx, target, service, endpoint)
+	if netErr != nil {
+		return netErr
+	}
+	defer stream.Close()
+
+	if err := stream.Send(&codec.Frame{Data: body}); err != nil {
+		return grpcclient.ToNetError(err)
+	}
+	if err := grpcx.CloseSend(stream); err != nil {
+		return grpcclient.ToNetError(err)
+	}
+
+	flusher, _ := resp.w.(http.Flusher)
+	for {
+		frame := &codec.Frame{}
+		if err := stream.Recv(frame); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return grpcclient.ToNetError(err)
+		}
+		if !resp.hasWrite {
+			h.writeHeader(resp.w, p)
+			resp.hasWrite = true
+		}
+		if err := p.writeFrame(resp.w, 0, frame.Data); err != nil {
+			return neterrors.BadRequest("[gate] write frame fail %s", err.Error())
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *GrpcWebHandler) writeHeader(w http.ResponseWriter, p *webProtocol) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeError 按协议返回错误, 用于尚未写出响应时
+func (h *GrpcWebHandler) writeError(w http.ResponseWriter, r *http.Request, p *webProtocol, netErr *neterrors.NetError) {
+	logger.Errorf("[gate] with error ret:%s url:%s", netErr, r.RequestURI)
+
+	if p.streaming() {
+		h.writeHeader(w, p)
+		if err := p.writeEnd(w, netErr); err != nil {
+			logger.Errorf("[gate] write end frame fail url:%s err:%s", r.RequestURI, err)
+		}
+		return
+	}
+
+	// connect普通请求: http状态码+json错误体
+	cerr := connectError(netErr)
+	b, _ := json.Marshal(cerr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Error-Code", strconv.Itoa(int(netErr.Code)))
+	w.WriteHeader(connectCodes[grpcCode(netErr)].status)
+	w.Write(b)
+}
+
+// webServiceMethod 解析/{package}.{Struct}/{Method}
+func webServiceMethod(path string) (service string, endpoint string, err error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("malformed path:%s", path)
+	}
+	fullService, method := parts[len(parts)-2], parts[len(parts)-1]
+
+	names := strings.Split(fullService, ".")
+	if len(names) < 2 || method == "" {
+		return "", "", fmt.Errorf("malformed path:%s", path)
+	}
+	service = names[len(names)-2]
+	endpoint = names[len(names)-1] + "." + method
+	return service, endpoint, nil
+}
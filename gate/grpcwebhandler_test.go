@@ -0,0 +1,124 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+func readWebFrames(t *testing.T, b []byte) (msgs []string, trailer string) {
+	for len(b) > 0 {
+		if len(b) < 5 {
+			t.Fatalf("invalid frame %v", b)
+		}
+		size := binary.BigEndian.Uint32(b[1:5])
+		payload := string(b[5 : 5+size])
+		if b[0]&grpcWebFlagTrailer != 0 {
+			trailer = payload
+		} else {
+			msgs = append(msgs, payload)
+		}
+		b = b[5+size:]
+	}
+	return msgs, trailer
+}
+
+func TestGrpcWebHandler(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+		grpcserver.HandleServerStream(svr, "EchoService.Count", func(ctx context.Context, req *echoReq, stream *grpcserver.ServerStream[echoReq, echoResp]) error {
+			for i := 0; i < 3; i++ {
+				if err := stream.Send(&echoResp{Msg: fmt.Sprintf("%s%d", req.Name, i)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	target := HttpTarget(func(service string) string { return addr })
+	h := NewGrpcWebHandler(target, HttpWebStreams("echo/EchoService.Count"))
+
+	// grpc-web 服务端流
+	body := encodeWebFrame(0, []byte(`{"name":"n"}`))
+	r := httptest.NewRequest(http.MethodPost, "/vkit.echo.EchoService/Count", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc-web+json")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	msgs, trailer := readWebFrames(t, w.Body.Bytes())
+	if len(msgs) != 3 || msgs[2] != `{"msg":"n2"}` || !strings.Contains(trailer, "grpc-status: 0") {
+		t.Fatalf("unexpected grpc-web response %q %q", msgs, trailer)
+	}
+
+	// grpc-web 一元调用的结果经过拦截器, 拦截器可读取及替换响应
+	var seen string
+	wrap := func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+			err := next(ctx, req, resp)
+			seen = string(resp.Content())
+			return err
+		}
+	}
+	wh := NewGrpcWebHandler(target, HttpWrapHandler(wrap))
+	body = encodeWebFrame(0, []byte(`{"name":"c"}`))
+	r = httptest.NewRequest(http.MethodPost, "/vkit.echo.EchoService/Hello", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc-web+json")
+	w = httptest.NewRecorder()
+	wh.Handle(w, r)
+	msgs, trailer = readWebFrames(t, w.Body.Bytes())
+	if seen != `{"msg":"hello c"}` || len(msgs) != 1 || msgs[0] != seen || !strings.Contains(trailer, "grpc-status: 0") {
+		t.Fatalf("unexpected grpc-web unary response %s %q %q", seen, msgs, trailer)
+	}
+
+	// 未配置cors策略时不处理预检
+	r = httptest.NewRequest(http.MethodOptions, "/vkit.echo.EchoService/Hello", nil)
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected options status %d", w.Code)
+	}
+
+	// grpc-web-text 错误
+	body = []byte(base64.StdEncoding.EncodeToString(encodeWebFrame(0, []byte(`{}`))))
+	r = httptest.NewRequest(http.MethodPost, "/echo.EchoService/Missing", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc-web-text+json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	raw, err := base64.StdEncoding.DecodeString(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, trailer = readWebFrames(t, raw); !strings.Contains(trailer, "grpc-status: 5") {
+		t.Fatalf("unexpected grpc-web-text trailer %q", trailer)
+	}
+
+	// connect 普通请求
+	r = httptest.NewRequest(http.MethodPost, "/echo.EchoService/Hello", strings.NewReader(`{"name":"vkit"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	resp := &echoResp{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil || resp.Msg != "hello vkit" {
+		t.Fatalf("unexpected connect response %d %s", w.Code, w.Body.String())
+	}
+
+	// connect 错误
+	r = httptest.NewRequest(http.MethodPost, "/echo.EchoService/Missing", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"not_found"`) {
+		t.Fatalf("unexpected connect error %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,20 @@
+package gate
+
+import (
+	"context"
+
+	"github.com/vison888/go-vkit/grpcx"
+)
+
+// Handle 为NativeHandler注册类型化handler, 签名在编译期校验, 调用时不经过反射
+// url为Struct.Method或完整请求路径
+func Handle[Req, Resp any](h *NativeHandler, url string, fn func(ctx context.Context, req *Req, resp *Resp) error) {
+	h.register(url, "", &handlerInfo{Invoker: grpcx.TypedInvoker(fn)})
+}
+
+// Endpoints 返回已注册的接口, 用于生成接口文档, 服务名由HttpServiceName设置
+func (h *NativeHandler) Endpoints() []*grpcx.EndpointInfo {
+	return grpcx.Endpoints(h.handlers, func(hi *handlerInfo) *grpcx.EndpointInfo {
+		return hi.EndpointInfo(h.opts.ServiceName, hi.method, hi.url, hi.clientStream, hi.serverStream)
+	})
+}
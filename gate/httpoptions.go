@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcx"
 	"github.com/vison888/go-vkit/logger"
 )
 
@@ -23,29 +25,60 @@ var (
 		logger.Error(string(debug.Stack()))
 		ErrorResponse(w, r, neterrors.InternalServerError(errorStr))
 	}
+	// 公网cdn, 浏览器打开文档页面时需能访问
+	DefaultOpenApiUICdn = "https://unpkg.com/swagger-ui-dist@5"
 )
 
 type HttpOptions struct {
-	GrpcPort     int
-	ErrHandler   func(w http.ResponseWriter, r *http.Request, err any)
-	AuthHandler  func(w http.ResponseWriter, r *http.Request) error
-	HdlrWrappers []HandlerWrapper
+	GrpcPort int
+	// NativeHandler提供的服务名, 用于生成接口文档
+	ServiceName string
+	// 根据服务名返回grpc地址, 缺省为service:GrpcPort
+	Target func(service string) string
+	// 按服务划分流量, key为空时作用于所有服务, 未命中时使用Target
+	Traffic map[string]*TrafficPolicy
+	// 转发超时, 0为使用grpcclient的RequestTimeout
+	Timeout time.Duration
+	// 按服务或服务/接口设置的超时, 优先于Timeout
+	RouteTimeouts map[string]time.Duration
+	ErrHandler    func(w http.ResponseWriter, r *http.Request, err any)
+	AuthHandler   func(w http.ResponseWriter, r *http.Request) error
+	// 受信请求头, 鉴权前删除客户端传入的值, 只能由AuthHandler设置, 如x-user-id
+	TrustedHeaders []string
+	HdlrWrappers   []HandlerWrapper
 	// ws
-	WsUpgrader       *websocket.Upgrader
-	WsPingPeriod     time.Duration
-	WsMaxMessageSize int
+	WsUpgrader        *websocket.Upgrader
+	WsPingPeriod      time.Duration
+	WsMaxMessageSize  int
+	WsReadBufferSize  int
+	WsWriteBufferSize int
+	WsBackpressure    WsBackpressure
+	// 断线后会话保留时长, 0为不开启续传
+	WsResumeWindow time.Duration
+	// 续传可补发的已发送帧数
+	WsReplaySize int
+	// 会话归属, 续传时须与创建时一致, 缺省为Authorization及Cookie头的摘要
+	WsSessionOwner func(r *http.Request) string
+	// GrpcWebHandler按服务端流转发的接口, key为服务名/接口名, 其余gRPC-Web请求按一元调用转发
+	WebStreams map[string]bool
+	// openapi文档页面引用的外部swagger-ui-dist地址, 为空时不提供页面
+	OpenApiUICdn string
 }
 
 type HttpOption func(o *HttpOptions)
 
 func newHttpOptions(opts ...HttpOption) HttpOptions {
 	opt := HttpOptions{
-		GrpcPort:         DefaultGrpcPort,
-		ErrHandler:       DefaultErrHandler,
-		HdlrWrappers:     make([]HandlerWrapper, 0),
-		WsUpgrader:       DefaultUpgrader,
-		WsPingPeriod:     DefaultWsPingPeriod,
-		WsMaxMessageSize: DefaultWsMaxMessageSize,
+		GrpcPort:          DefaultGrpcPort,
+		ErrHandler:        DefaultErrHandler,
+		HdlrWrappers:      make([]HandlerWrapper, 0),
+		WsUpgrader:        DefaultUpgrader,
+		WsPingPeriod:      DefaultWsPingPeriod,
+		WsMaxMessageSize:  DefaultWsMaxMessageSize,
+		WsReadBufferSize:  DefaultWsReadBufferSize,
+		WsWriteBufferSize: DefaultWsWriteBufferSize,
+		WsBackpressure:    WsBackpressureBlock,
+		WsReplaySize:      DefaultWsReplaySize,
 	}
 	for _, o := range opts {
 		o(&opt)
@@ -53,12 +86,68 @@ func newHttpOptions(opts ...HttpOption) HttpOptions {
 	return opt
 }
 
+func (o *HttpOptions) target(service string) string {
+	if o.Target != nil {
+		return o.Target(service)
+	}
+	return fmt.Sprintf("%s:%d", service, o.GrpcPort)
+}
+
+// route 按流量划分选择地址, 服务级优先于全局
+func (o *HttpOptions) route(ctx context.Context, service string, r *http.Request) string {
+	p, ok := o.Traffic[service]
+	if !ok {
+		p = o.Traffic[""]
+	}
+	if p != nil {
+		if addr := p.pick(ctx, r); addr != "" {
+			return addr
+		}
+	}
+	return o.target(service)
+}
+
+// wrap 按注册顺序包装拦截器, 先注册的在外层
+func (o *HttpOptions) wrap(fn HandlerFunc) HandlerFunc {
+	for i := len(o.HdlrWrappers); i > 0; i-- {
+		fn = o.HdlrWrappers[i-1](fn)
+	}
+	return fn
+}
+
+// webStream gRPC-Web请求是否按服务端流转发
+func (o *HttpOptions) webStream(service, endpoint string) bool {
+	return o.WebStreams[service+"/"+endpoint]
+}
+
+// timeout 接口级 > 服务级 > Timeout
+func (o *HttpOptions) timeout(service, endpoint string) time.Duration {
+	if d, ok := o.RouteTimeouts[service+"/"+endpoint]; ok {
+		return d
+	}
+	if d, ok := o.RouteTimeouts[service]; ok {
+		return d
+	}
+	return o.Timeout
+}
+
 func HttpWrapHandler(w HandlerWrapper) HttpOption {
 	return func(o *HttpOptions) {
 		o.HdlrWrappers = append(o.HdlrWrappers, w)
 	}
 }
 
+// HttpOpenApiCdnUI OpenApiHandler在非json路径上提供文档页面, 页面从cdn加载swagger-ui-dist
+// 网关未内嵌swagger-ui资源, cdn为空时使用DefaultOpenApiUICdn, 内网部署需自行托管swagger-ui-dist并传入其地址
+func HttpOpenApiCdnUI(cdn string) HttpOption {
+	return func(o *HttpOptions) {
+		if cdn == "" {
+			cdn = DefaultOpenApiUICdn
+		}
+		o.OpenApiUICdn = strings.TrimSuffix(cdn, "/")
+	}
+}
+
 func HttpErrHandler(h func(w http.ResponseWriter, r *http.Request, err any)) HttpOption {
 	return func(o *HttpOptions) {
 		o.ErrHandler = h
@@ -71,12 +160,95 @@ func HttpGrpcPort(port int) HttpOption {
 	}
 }
 
+func HttpTarget(target func(service string) string) HttpOption {
+	return func(o *HttpOptions) {
+		o.Target = target
+	}
+}
+
+func HttpTimeout(timeout time.Duration) HttpOption {
+	return func(o *HttpOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// HttpRouteTimeout route为服务名或服务名/接口名, 如"sso"、"sso/AuthService.Login"
+func HttpRouteTimeout(route string, timeout time.Duration) HttpOption {
+	return func(o *HttpOptions) {
+		if o.RouteTimeouts == nil {
+			o.RouteTimeouts = make(map[string]time.Duration)
+		}
+		o.RouteTimeouts[route] = timeout
+	}
+}
+
+// HttpWebStreams 设置gRPC-Web的服务端流接口, route为服务名/接口名, 如"sso/AuthService.Watch"
+// Connect协议按content-type区分一元及流式调用, 不需要设置
+func HttpWebStreams(routes ...string) HttpOption {
+	return func(o *HttpOptions) {
+		if o.WebStreams == nil {
+			o.WebStreams = make(map[string]bool)
+		}
+		for _, route := range routes {
+			o.WebStreams[route] = true
+		}
+	}
+}
+
+// HttpWebEndpoints 按接口描述设置gRPC-Web的服务端流接口, 如GrpcServer.Endpoints()的返回
+func HttpWebEndpoints(eps []*grpcx.EndpointInfo) HttpOption {
+	routes := make([]string, 0)
+	for _, ep := range eps {
+		if ep.ServerStream {
+			routes = append(routes, ep.Service+"/"+ep.Url)
+		}
+	}
+	return HttpWebStreams(routes...)
+}
+
+// HttpTraffic 设置服务的流量划分, service为空时作用于所有服务, 配置非法时不生效
+func HttpTraffic(service string, p *TrafficPolicy) HttpOption {
+	return func(o *HttpOptions) {
+		if err := p.Validate(); err != nil {
+			logger.Errorf("[gate] traffic policy service:%s invalid:%s", service, err)
+			return
+		}
+		if o.Traffic == nil {
+			o.Traffic = make(map[string]*TrafficPolicy)
+		}
+		o.Traffic[service] = p
+	}
+}
+
+func HttpServiceName(name string) HttpOption {
+	return func(o *HttpOptions) {
+		o.ServiceName = name
+	}
+}
+
 func HttpAuthHandler(h func(w http.ResponseWriter, r *http.Request) error) HttpOption {
 	return func(o *HttpOptions) {
 		o.AuthHandler = h
 	}
 }
 
+// HttpTrustedHeaders 设置受信请求头, 客户端传入的同名头在鉴权前被删除
+func HttpTrustedHeaders(keys ...string) HttpOption {
+	return func(o *HttpOptions) {
+		o.TrustedHeaders = append(o.TrustedHeaders, keys...)
+	}
+}
+
+// HttpTransform 添加请求及响应转换, Inject的key同时设为受信请求头, 避免客户端伪造注入值
+func HttpTransform(cfg *TransformConfig) HttpOption {
+	return func(o *HttpOptions) {
+		for _, in := range cfg.Inject {
+			o.TrustedHeaders = append(o.TrustedHeaders, in.Key)
+		}
+		o.HdlrWrappers = append(o.HdlrWrappers, TransformWrapper(cfg))
+	}
+}
+
 func WsUpgrader(upgrader *websocket.Upgrader) HttpOption {
 	return func(o *HttpOptions) {
 		o.WsUpgrader = upgrader
@@ -94,3 +266,30 @@ func WsMaxMessageSize(wsMaxMessageSize int) HttpOption {
 		o.WsMaxMessageSize = wsMaxMessageSize
 	}
 }
+
+func WsBufferSize(readBufferSize, writeBufferSize int) HttpOption {
+	return func(o *HttpOptions) {
+		o.WsReadBufferSize = readBufferSize
+		o.WsWriteBufferSize = writeBufferSize
+	}
+}
+
+func WsBackpressurePolicy(policy WsBackpressure) HttpOption {
+	return func(o *HttpOptions) {
+		o.WsBackpressure = policy
+	}
+}
+
+func WsResume(window time.Duration, replaySize int) HttpOption {
+	return func(o *HttpOptions) {
+		o.WsResumeWindow = window
+		o.WsReplaySize = replaySize
+	}
+}
+
+// WsSessionOwner 设置会话归属, 如返回鉴权得到的用户id, 其他调用方无法接管会话
+func WsSessionOwner(fn func(r *http.Request) string) HttpOption {
+	return func(o *HttpOptions) {
+		o.WsSessionOwner = fn
+	}
+}
@@ -11,6 +11,7 @@ type HttpRequest struct {
 	r           *http.Request
 	service     string
 	method      string
+	endpoint    string
 	contentType string
 	body        []byte
 	hasRead     bool
@@ -34,7 +35,7 @@ func (r *HttpRequest) Method() string {
 }
 
 func (r *HttpRequest) Endpoint() string {
-	return r.method
+	return r.endpoint
 }
 
 func (r *HttpRequest) Uri() string {
@@ -0,0 +1,318 @@
+package gate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/logger"
+	"github.com/vison888/go-vkit/redisx"
+)
+
+var (
+	DefaultIdempotencyHeader  = "Idempotency-Key"
+	DefaultIdempotencyTTL     = time.Hour * 24
+	DefaultIdempotencyLockTTL = time.Minute
+)
+
+// IdempotencyRecord 幂等键对应的首次处理结果
+type IdempotencyRecord struct {
+	// 处理中, 尚无结果
+	Pending bool `json:"pending,omitempty"`
+	// 请求体摘要, 同一幂等键请求体不同时拒绝
+	Fingerprint string `json:"fingerprint"`
+	Body        []byte `json:"body,omitempty"`
+	// 首次处理时设置的响应头, 重放时一并返回
+	Header http.Header `json:"header,omitempty"`
+	// 首次处理返回的错误, 重放时按其状态码返回
+	Err *neterrors.NetError `json:"err,omitempty"`
+}
+
+// IdempotencyStorage 幂等记录存储
+type IdempotencyStorage interface {
+	// Reserve key不存在时写入rec并返回true, 否则返回已有记录
+	Reserve(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (bool, *IdempotencyRecord, error)
+	Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+type IdempotencyOptions struct {
+	Store IdempotencyStorage
+	// 请求头名
+	Header string
+	// 结果保存时长
+	TTL time.Duration
+	// 处理中状态的保存时长, 网关异常退出后超过该时长可重新处理
+	LockTTL time.Duration
+	// 生成存储键, 缺省为请求路径+调用方凭证摘要+幂等键, 不同调用方使用同一幂等键互不影响
+	// 凭证会变化(如token刷新)时应改用用户标识
+	KeyFunc func(req *HttpRequest, key string) string
+}
+
+type IdempotencyOption func(o *IdempotencyOptions)
+
+func newIdempotencyOptions(opts ...IdempotencyOption) IdempotencyOptions {
+	opt := IdempotencyOptions{
+		Header:  DefaultIdempotencyHeader,
+		TTL:     DefaultIdempotencyTTL,
+		LockTTL: DefaultIdempotencyLockTTL,
+		KeyFunc: func(req *HttpRequest, key string) string {
+			r := req.Request()
+			return r.URL.Path + ":" + credentialDigest(r) + ":" + key
+		},
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.Store == nil {
+		opt.Store = NewMemoryIdempotencyStore()
+	}
+	return opt
+}
+
+func IdempotencyStore(s IdempotencyStorage) IdempotencyOption {
+	return func(o *IdempotencyOptions) {
+		o.Store = s
+	}
+}
+
+func IdempotencyHeader(h string) IdempotencyOption {
+	return func(o *IdempotencyOptions) {
+		o.Header = h
+	}
+}
+
+func IdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(o *IdempotencyOptions) {
+		o.TTL = ttl
+	}
+}
+
+func IdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(o *IdempotencyOptions) {
+		o.LockTTL = ttl
+	}
+}
+
+func IdempotencyKeyFunc(fn func(req *HttpRequest, key string) string) IdempotencyOption {
+	return func(o *IdempotencyOptions) {
+		o.KeyFunc = fn
+	}
+}
+
+// IdempotencyWrapper 按Idempotency-Key头对修改类请求去重
+// 首次请求的结果保存TTL时长, 重复请求直接返回该结果, 首次请求处理中时返回409
+// 5xx及超时、限流等可重试的错误不保存, 客户端可使用同一幂等键重试
+func IdempotencyWrapper(opts ...IdempotencyOption) HandlerWrapper {
+	o := newIdempotencyOptions(opts...)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+			r := req.Request()
+			idemKey := r.Header.Get(o.Header)
+			if idemKey == "" || !isMutating(r.Method) {
+				return next(ctx, req, resp)
+			}
+
+			body, _, err := req.Read()
+			if err != nil {
+				return neterrors.BadRequest("[gate] %s url:%s", err.Error(), r.RequestURI)
+			}
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			key := o.KeyFunc(req, idemKey)
+			ok, rec, err := o.Store.Reserve(ctx, key, &IdempotencyRecord{Pending: true, Fingerprint: fingerprint}, o.LockTTL)
+			if err != nil {
+				logger.Errorf("[gate] idempotency reserve key:%s fail:%s", key, err)
+				return neterrors.ServiceUnavailable("[gate] idempotency store unavailable")
+			}
+			if !ok {
+				return replay(rec, fingerprint, idemKey, resp)
+			}
+
+			before := resp.w.Header().Clone()
+			appErr := next(ctx, req, resp)
+			if appErr != nil && !cacheable(appErr) {
+				if err := o.Store.Delete(context.Background(), key); err != nil {
+					logger.Errorf("[gate] idempotency delete key:%s fail:%s", key, err)
+				}
+				return appErr
+			}
+
+			done := &IdempotencyRecord{Fingerprint: fingerprint, Body: resp.content, Header: addedHeader(before, resp.w.Header())}
+			if appErr != nil {
+				done.Err = neterrors.FromError(appErr)
+			}
+			// 请求可能已取消, 保存结果不受其影响
+			if err := o.Store.Save(context.Background(), key, done, o.TTL); err != nil {
+				logger.Errorf("[gate] idempotency save key:%s fail:%s", key, err)
+			}
+			return appErr
+		}
+	}
+}
+
+func replay(rec *IdempotencyRecord, fingerprint, idemKey string, resp *HttpResponse) error {
+	if rec.Fingerprint != fingerprint {
+		return &neterrors.NetError{
+			Code:   -1,
+			Status: http.StatusUnprocessableEntity,
+			Msg:    "[gate] idempotency key reused with different body",
+		}
+	}
+	if rec.Pending {
+		return neterrors.Conflict("[gate] request with idempotency key:%s is in progress", idemKey)
+	}
+	for k, vs := range rec.Header {
+		resp.w.Header()[k] = vs
+	}
+	resp.w.Header().Set("Idempotent-Replayed", "true")
+	if rec.Err != nil {
+		return rec.Err
+	}
+	resp.content = rec.Body
+	return nil
+}
+
+// credentialDigest 调用方凭证(Authorization及Cookie头)的摘要, 无凭证时为空
+func credentialDigest(r *http.Request) string {
+	auth, cookie := r.Header.Get("Authorization"), r.Header.Get("Cookie")
+	if auth == "" && cookie == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(auth + "\n" + cookie))
+	return hex.EncodeToString(sum[:16])
+}
+
+// addedHeader 处理过程中新增或修改的响应头
+func addedHeader(before, after http.Header) http.Header {
+	added := make(http.Header)
+	for k, vs := range after {
+		if k == "Content-Length" || strings.Join(before[k], "\n") == strings.Join(vs, "\n") {
+			continue
+		}
+		added[k] = vs
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return added
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// cacheable 业务错误及4xx为确定结果, 可保存
+func cacheable(err error) bool {
+	verr := neterrors.FromError(err)
+	switch verr.Status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, 499:
+		return false
+	}
+	return verr.Status < http.StatusInternalServerError
+}
+
+type memoryIdempotencyStore struct {
+	sync.Mutex
+	records map[string]*memoryRecord
+	ops     int
+}
+
+type memoryRecord struct {
+	rec    *IdempotencyRecord
+	expire time.Time
+}
+
+// 每写入多少次清理过期记录
+const memoryIdempotencySweep = 1024
+
+// NewMemoryIdempotencyStore 进程内存储, 仅适用于单实例网关
+func NewMemoryIdempotencyStore() IdempotencyStorage {
+	return &memoryIdempotencyStore{records: make(map[string]*memoryRecord)}
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (bool, *IdempotencyRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if old, ok := s.records[key]; ok && now.Before(old.expire) {
+		return false, old.rec, nil
+	}
+	s.put(key, rec, ttl, now)
+	return true, nil, nil
+}
+
+func (s *memoryIdempotencyStore) Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.put(key, rec, ttl, time.Now())
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Delete(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) put(key string, rec *IdempotencyRecord, ttl time.Duration, now time.Time) {
+	s.records[key] = &memoryRecord{rec: rec, expire: now.Add(ttl)}
+	s.ops++
+	if s.ops%memoryIdempotencySweep != 0 {
+		return
+	}
+	for k, v := range s.records {
+		if now.After(v.expire) {
+			delete(s.records, k)
+		}
+	}
+}
+
+type redisIdempotencyStore struct {
+	c      *redisx.RedisClient
+	prefix string
+}
+
+// NewRedisIdempotencyStore redis存储, 多实例网关共享, key为prefix:存储键
+func NewRedisIdempotencyStore(c *redisx.RedisClient, prefix string) IdempotencyStorage {
+	return &redisIdempotencyStore{c: c, prefix: prefix}
+}
+
+func (s *redisIdempotencyStore) Reserve(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (bool, *IdempotencyRecord, error) {
+	rkey := &redisx.RedisKey{Code: s.prefix, Expire: ttl}
+	// 已有记录恰好过期时重试一次
+	for i := 0; i < 2; i++ {
+		ok, err := s.c.SetNXJson(rkey, key, rec)
+		if err != nil || ok {
+			return ok, nil, err
+		}
+		old := &IdempotencyRecord{}
+		err = s.c.GetJson(rkey, key, old)
+		if err == nil {
+			return false, old, nil
+		}
+		if !redisx.IsNil(err) {
+			return false, nil, err
+		}
+	}
+	return false, &IdempotencyRecord{Pending: true, Fingerprint: rec.Fingerprint}, nil
+}
+
+func (s *redisIdempotencyStore) Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	return s.c.SetJson(&redisx.RedisKey{Code: s.prefix, Expire: ttl}, key, rec)
+}
+
+func (s *redisIdempotencyStore) Delete(ctx context.Context, key string) error {
+	return s.c.Del(&redisx.RedisKey{Code: s.prefix}, key)
+}
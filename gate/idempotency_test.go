@@ -0,0 +1,107 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+)
+
+func idempotentCall(fn HandlerFunc, key, body string, auth ...string) (*HttpResponse, *httptest.ResponseRecorder, error) {
+	r := httptest.NewRequest(http.MethodPost, "/rpc/order/OrderService.Create", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", key)
+	if len(auth) > 0 {
+		r.Header.Set("Authorization", auth[0])
+	}
+	w := httptest.NewRecorder()
+	req := &HttpRequest{uri: r.RequestURI, r: r, contentType: "application/json"}
+	resp := &HttpResponse{w: w}
+	err := fn(context.Background(), req, resp)
+	return resp, w, err
+}
+
+func TestIdempotencyWrapper(t *testing.T) {
+	calls := 0
+	started, block := make(chan struct{}), make(chan struct{})
+	fn := IdempotencyWrapper()(func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+		calls++
+		body, _, _ := req.Read()
+		switch string(body) {
+		case `{"id":2}`:
+			close(started)
+			<-block
+		case `{"id":3}`:
+			return neterrors.ServiceUnavailable("busy")
+		case `{"id":4}`:
+			return neterrors.BusinessError(1001, "库存不足")
+		}
+		resp.w.Header().Set("Location", "/orders/1")
+		resp.content = []byte(`{"order":1}`)
+		return nil
+	})
+
+	// 重复请求返回首次结果
+	for i := 0; i < 2; i++ {
+		resp, w, err := idempotentCall(fn, "a", `{"id":1}`)
+		if err != nil || string(resp.content) != `{"order":1}` {
+			t.Fatalf("unexpected resp %s err:%v", resp.content, err)
+		}
+		if w.Header().Get("Location") != "/orders/1" {
+			t.Fatalf("response header lost, replay:%s", w.Header().Get("Idempotent-Replayed"))
+		}
+		if i == 1 && w.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatal("replay header not set")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("handler should be called once, calls:%d", calls)
+	}
+
+	// 不同调用方使用同一幂等键互不影响
+	for _, auth := range []string{"Bearer u1", "Bearer u2"} {
+		if _, w, err := idempotentCall(fn, "a", `{"id":1}`, auth); err != nil || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("caller %s should not replay, err:%v", auth, err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("handler should be called per caller, calls:%d", calls)
+	}
+
+	// 同一幂等键请求体不同
+	if _, _, err := idempotentCall(fn, "a", `{"id":9}`); neterrors.FromError(err).Status != http.StatusUnprocessableEntity {
+		t.Fatalf("body mismatch should fail, err:%v", err)
+	}
+
+	// 处理中的重复请求返回409
+	done := make(chan struct{})
+	go func() {
+		idempotentCall(fn, "b", `{"id":2}`)
+		close(done)
+	}()
+	<-started
+	if _, _, err := idempotentCall(fn, "b", `{"id":2}`); neterrors.FromError(err).Status != http.StatusConflict {
+		t.Fatalf("in-flight duplicate should conflict, err:%v", err)
+	}
+	close(block)
+	<-done
+
+	// 可重试的错误不保存
+	calls = 0
+	idempotentCall(fn, "c", `{"id":3}`)
+	idempotentCall(fn, "c", `{"id":3}`)
+	if calls != 2 {
+		t.Fatalf("retryable error should not be stored, calls:%d", calls)
+	}
+
+	// 业务错误保存后重放
+	calls = 0
+	idempotentCall(fn, "d", `{"id":4}`)
+	_, _, err := idempotentCall(fn, "d", `{"id":4}`)
+	if verr := neterrors.FromError(err); calls != 1 || verr.Code != 1001 {
+		t.Fatalf("business error should be replayed, calls:%d err:%v", calls, err)
+	}
+}
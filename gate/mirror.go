@@ -0,0 +1,203 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcclient"
+	"github.com/vison888/go-vkit/logger"
+	meta "github.com/vison888/go-vkit/metadata"
+)
+
+var (
+	DefaultMirrorTimeout       = time.Second * 5
+	DefaultMirrorMaxConcurrent = 100
+)
+
+// MirrorKey 镜像请求的metadata标记, 影子服务可据此跳过外部副作用
+const MirrorKey = "x-mirror"
+
+type MirrorOptions struct {
+	// 根据服务名返回影子服务的grpc地址
+	Target func(service string) string
+	// 镜像比例, 0~100
+	Percent float64
+	// 对比影子响应与主响应, 不一致时记录日志
+	Diff bool
+	// 影子请求超时, 与主请求无关
+	Timeout time.Duration
+	// 进行中的影子请求上限, 超过时丢弃
+	MaxConcurrent int
+}
+
+type MirrorOption func(o *MirrorOptions)
+
+func newMirrorOptions(opts ...MirrorOption) MirrorOptions {
+	opt := MirrorOptions{
+		Timeout:       DefaultMirrorTimeout,
+		MaxConcurrent: DefaultMirrorMaxConcurrent,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+// MirrorTarget 影子服务地址, 所有服务使用同一地址时可返回固定值
+func MirrorTarget(target func(service string) string) MirrorOption {
+	return func(o *MirrorOptions) {
+		o.Target = target
+	}
+}
+
+func MirrorPercent(percent float64) MirrorOption {
+	return func(o *MirrorOptions) {
+		o.Percent = percent
+	}
+}
+
+func MirrorDiff(diff bool) MirrorOption {
+	return func(o *MirrorOptions) {
+		o.Diff = diff
+	}
+}
+
+func MirrorTimeout(timeout time.Duration) MirrorOption {
+	return func(o *MirrorOptions) {
+		o.Timeout = timeout
+	}
+}
+
+func MirrorMaxConcurrent(n int) MirrorOption {
+	return func(o *MirrorOptions) {
+		o.MaxConcurrent = n
+	}
+}
+
+// MirrorStats 镜像统计
+type MirrorStats struct {
+	// 发出的影子请求数
+	Mirrored int64
+	// 因并发上限丢弃的请求数
+	Dropped int64
+	// 影子请求返回错误的次数
+	Errors int64
+	// 开启Diff时与主响应不一致的次数
+	Mismatches int64
+}
+
+// Mirror 按比例将GrpcHandler的请求异步复制到影子服务, 丢弃影子响应
+type Mirror struct {
+	opts MirrorOptions
+	sem  chan struct{}
+
+	mirrored   int64
+	dropped    int64
+	errors     int64
+	mismatches int64
+}
+
+func NewMirror(opts ...MirrorOption) *Mirror {
+	o := newMirrorOptions(opts...)
+	if o.MaxConcurrent <= 0 {
+		o.MaxConcurrent = DefaultMirrorMaxConcurrent
+	}
+	return &Mirror{opts: o, sem: make(chan struct{}, o.MaxConcurrent)}
+}
+
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Mirrored:   atomic.LoadInt64(&m.mirrored),
+		Dropped:    atomic.LoadInt64(&m.dropped),
+		Errors:     atomic.LoadInt64(&m.errors),
+		Mismatches: atomic.LoadInt64(&m.mismatches),
+	}
+}
+
+// Wrapper 用于GrpcHandler的HttpWrapHandler, 主请求完成后再发出影子请求, 不影响主请求耗时及结果
+func (m *Mirror) Wrapper() HandlerWrapper {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+			appErr := next(ctx, req, resp)
+			if m.opts.Target == nil || rand.Float64()*100 >= m.opts.Percent {
+				return appErr
+			}
+			body, _, err := req.Read()
+			if err != nil {
+				return appErr
+			}
+
+			select {
+			case m.sem <- struct{}{}:
+			default:
+				atomic.AddInt64(&m.dropped, 1)
+				return appErr
+			}
+			atomic.AddInt64(&m.mirrored, 1)
+
+			md := meta.Metadata{}
+			if v, ok := meta.FromContext(ctx); ok {
+				md = meta.Copy(v)
+			}
+			md[MirrorKey] = "true"
+			var primaryErr *neterrors.NetError
+			if appErr != nil {
+				primaryErr = neterrors.FromError(appErr)
+			}
+			go m.shadow(md, req.Service(), req.Endpoint(), body, resp.content, primaryErr)
+			return appErr
+		}
+	}
+}
+
+func (m *Mirror) shadow(md meta.Metadata, service, endpoint string, body, primary []byte, primaryErr *neterrors.NetError) {
+	defer func() {
+		<-m.sem
+		if re := recover(); re != nil {
+			logger.Errorf("[gate] mirror panic recovered:%v", re)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(meta.NewContext(context.Background(), md), m.opts.Timeout)
+	defer cancel()
+	target := m.opts.Target(service)
+	raw, netErr := grpcclient.InvokeByGate(ctx, target, service, endpoint, body)
+	if netErr != nil {
+		atomic.AddInt64(&m.errors, 1)
+	}
+	if !m.opts.Diff {
+		return
+	}
+
+	var shadow []byte
+	if raw != nil {
+		shadow = *raw
+	}
+	if !sameResult(primary, primaryErr, shadow, netErr) {
+		atomic.AddInt64(&m.mismatches, 1)
+		logger.Infof("[gate] mirror mismatch service:%s endpoint:%s target:%s primary:%s primaryErr:%v shadow:%s shadowErr:%v",
+			service, endpoint, target, primary, primaryErr, shadow, netErr)
+	}
+}
+
+// sameResult 成功时按json语义比较响应, 失败时比较错误码及状态码
+func sameResult(primary []byte, primaryErr *neterrors.NetError, shadow []byte, shadowErr *neterrors.NetError) bool {
+	if primaryErr != nil || shadowErr != nil {
+		return primaryErr != nil && shadowErr != nil &&
+			primaryErr.Code == shadowErr.Code && primaryErr.Status == shadowErr.Status
+	}
+	if bytes.Equal(primary, shadow) {
+		return true
+	}
+	var a, b any
+	if json.Unmarshal(primary, &a) != nil || json.Unmarshal(shadow, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,78 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/grpcserver"
+	meta "github.com/vison888/go-vkit/metadata"
+)
+
+func TestMirror(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+	})
+	var marked int64
+	shadowAddr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			if md, ok := meta.FromContext(ctx); ok {
+				if v, _ := md.Get(MirrorKey); v == "true" {
+					atomic.AddInt64(&marked, 1)
+				}
+			}
+			// 名字为same时与主服务响应一致
+			if req.Name == "same" {
+				resp.Msg = "hello " + req.Name
+			} else {
+				resp.Msg = "shadow " + req.Name
+			}
+			return nil
+		})
+	})
+
+	m := NewMirror(MirrorTarget(func(service string) string {
+		return shadowAddr
+	}), MirrorPercent(100), MirrorDiff(true))
+	h := NewGrpcHandler(HttpTarget(func(service string) string {
+		return addr
+	}), HttpWrapHandler(m.Wrapper()))
+
+	for _, name := range []string{"a", "b", "same"} {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(fmt.Sprintf(`{"name":"%s"}`, name)))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.Handle(w, r)
+		// 主响应不受影子服务影响
+		if w.Code != 200 || !strings.Contains(w.Body.String(), "hello "+name) {
+			t.Fatalf("unexpected primary resp %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 3)
+	for time.Now().Before(deadline) && atomic.LoadInt64(&marked) < 3 {
+		time.Sleep(time.Millisecond * 20)
+	}
+	time.Sleep(time.Millisecond * 50)
+	st := m.Stats()
+	if st.Mirrored != 3 || st.Errors != 0 || st.Mismatches != 2 || atomic.LoadInt64(&marked) != 3 {
+		t.Fatalf("unexpected stats %+v marked:%d", st, marked)
+	}
+}
+
+func TestSameResult(t *testing.T) {
+	if !sameResult([]byte(`{"a":1,"b":2}`), nil, []byte(`{"b":2, "a":1}`), nil) {
+		t.Fatal("json with different key order should be same")
+	}
+	if sameResult([]byte(`{"a":1}`), nil, []byte(`{"a":2}`), nil) {
+		t.Fatal("different value should mismatch")
+	}
+}
@@ -2,10 +2,10 @@ package gate
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
-	"strconv"
 	"strings"
 
 	"github.com/vison888/go-vkit/codec"
@@ -13,6 +13,7 @@ import (
 	"github.com/vison888/go-vkit/errorsx/neterrors"
 	"github.com/vison888/go-vkit/grpcx"
 	"github.com/vison888/go-vkit/logger"
+	"github.com/vison888/go-vkit/validatex"
 	"google.golang.org/grpc/encoding"
 	gmetadata "google.golang.org/grpc/metadata"
 )
@@ -36,10 +37,9 @@ func (h *NativeHandler) Init(opts ...HttpOption) {
 }
 
 type handlerInfo struct {
-	handler      reflect.Value
-	method       reflect.Method
-	reqType      reflect.Type
-	respType     reflect.Type
+	grpcx.Invoker
+	url          string
+	method       string
 	clientStream bool
 	serverStream bool
 }
@@ -81,34 +81,24 @@ func (h *NativeHandler) RegisterWithUrl(i any, apiEndpointMap map[string]*grpcx.
 			serverStream = desc.ServerStream
 			reqMethod = desc.Method
 		}
-		var reqType reflect.Type
-		var respType reflect.Type
-		if m.Type.NumIn() == 3 {
-			respType = m.Type.In(2)
-		} else if m.Type.NumIn() == 4 {
-			reqType = m.Type.In(2)
-			respType = m.Type.In(3)
-		} else {
-			panic("in param numbre error methodName:=" + methodName)
-		}
-
-		handler := &handlerInfo{
-			handler:      o,
-			method:       m,
-			reqType:      reqType,
-			respType:     respType,
-			clientStream: clientStream,
-			serverStream: serverStream,
-		}
+		handler := &handlerInfo{Invoker: grpcx.ReflectInvoker(o, m, methodName)}
+		handler.clientStream = clientStream
+		handler.serverStream = serverStream
 
-		h.handlers[reqUrl] = handler
-		if reqMethod != "" {
-			h.handlers[reqMethod] = handler
-		}
+		h.register(reqUrl, reqMethod, handler)
 	}
 	return nil
 }
 
+func (h *NativeHandler) register(reqUrl string, reqMethod string, handler *handlerInfo) {
+	handler.url = reqUrl
+	handler.method = reqMethod
+	h.handlers[reqUrl] = handler
+	if reqMethod != "" {
+		h.handlers[reqMethod] = handler
+	}
+}
+
 func (h *NativeHandler) Register(i any) (err error) {
 	return h.RegisterWithUrl(i, nil)
 }
@@ -133,6 +123,7 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	stripHeaders(r, h.opts.TrustedHeaders)
 	// 鉴权
 	if h.opts.AuthHandler != nil {
 		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
@@ -171,6 +162,7 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		r:           r,
 		service:     service,
 		method:      method,
+		endpoint:    endpoint,
 		contentType: readCt,
 		body:        nil,
 		hasRead:     false,
@@ -188,7 +180,7 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		hdr[k] = r.Header.Get(k)
 	}
 	md := gmetadata.New(hdr)
-	fullCtx := gmetadata.NewIncomingContext(context.Background(), md)
+	fullCtx := gmetadata.NewIncomingContext(r.Context(), md)
 	// 主逻辑
 	fn := func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
 		reqBytes, files, err := request.Read()
@@ -206,8 +198,12 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 			return neterrors.BadRequest(errorStr)
 		}
 
-		argv := reflect.New(hi.reqType.Elem())
-		replyv := reflect.New(hi.respType.Elem())
+		if hi.NewReq == nil {
+			errorStr := fmt.Sprintf("method %s not support", endpoint)
+			return neterrors.BadRequest(errorStr)
+		}
+		argv := hi.NewReq()
+		replyv := hi.NewResp()
 
 		var cd encoding.Codec
 		if cd = codec.DefaultGRPCCodecs[readCt]; cd.Name() != "json" {
@@ -215,7 +211,7 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 			return neterrors.BadRequest(errorStr)
 		}
 
-		if err := cd.Unmarshal(reqBytes, argv.Interface()); err != nil {
+		if err := cd.Unmarshal(reqBytes, argv); err != nil {
 			errorStr := fmt.Sprintf("Unmarshal error: %s", err.Error())
 			return neterrors.BadRequest(errorStr)
 		}
@@ -231,51 +227,43 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 				filenamesRet[k] = v.Filename
 			}
 			// 检查回调
-			field1 := argv.Elem().FieldByName("Files")
+			field1 := reflect.ValueOf(argv).Elem().FieldByName("Files")
 			if field1.CanSet() {
 				field1.Set(reflect.ValueOf(filesRet))
 			}
-			field2 := argv.Elem().FieldByName("Filenames")
+			field2 := reflect.ValueOf(argv).Elem().FieldByName("Filenames")
 			if field2.CanSet() {
 				field2.Set(reflect.ValueOf(filenamesRet))
 			}
 		}
 
 		// validate
-		validateFunc, b := hi.reqType.MethodByName("Validate")
-		if b {
-			out := validateFunc.Func.Call([]reflect.Value{reflect.ValueOf(argv.Interface())})
-			errValue := out[0]
-			if errValue.Interface() != nil {
-				err := errValue.Interface().(error)
-				errorStr := fmt.Sprintf("param error: %s", err.Error())
-				return neterrors.BusinessError(-1, errorStr)
-			}
+		if err := validatex.Check(argv); err != nil {
+			return err
 		}
 
-		in := make([]reflect.Value, 4)
-		in[0] = hi.handler
-		in[1] = reflect.ValueOf(ctx)
-		in[2] = argv
-		in[3] = replyv
-		out := hi.method.Func.Call(in)
-		if rerr := out[0].Interface(); rerr != nil {
+		if rerr := hi.Call(ctx, argv, replyv); rerr != nil {
 			//处理业务异常
-			if verr, ok := rerr.(*errorsx.Errno); ok {
+			var verr *errorsx.Errno
+			var nerr *neterrors.NetError
+			if errors.As(rerr, &verr) {
 				if verr.Code != 0 {
-					errorStr := fmt.Sprintf("call error: %s", verr.Error())
-					logger.Errorf(errorStr)
-					return neterrors.BusinessError(verr.Code, verr.Msg)
+					logger.Errorf("call error: %s %s", rerr.Error(), neterrors.StackOf(rerr))
+					return verr.ToNetError()
 				}
+			} else if errors.As(rerr, &nerr) {
+				//NetError原样返回, 保留详情
+				logger.Errorf("call error: %s %s", rerr.Error(), neterrors.StackOf(rerr))
+				return nerr
 			} else {
 				//其他异常统一包装
-				errorStr := fmt.Sprintf("call error: %s", rerr.(error).Error())
+				errorStr := fmt.Sprintf("call error: %s", rerr.Error())
 				logger.Errorf(errorStr)
 				return neterrors.BusinessError(-2, errorStr)
 			}
 		}
 
-		respBytes, err := cd.Marshal(replyv.Interface())
+		respBytes, err := cd.Marshal(replyv)
 		if err != nil {
 			logger.Infof("jsonRaw Marshal fail:%s", err)
 			return neterrors.BadRequest(err.Error())
@@ -285,25 +273,6 @@ func (h *NativeHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return nil
 	}
 	// 拦截器
-	for i := len(h.opts.HdlrWrappers); i > 0; i-- {
-		fn = h.opts.HdlrWrappers[i-1](fn)
-	}
-
-	if appErr := fn(fullCtx, request, response); appErr != nil {
-		switch verr := appErr.(type) {
-		case *neterrors.NetError:
-			ErrorResponse(w, r, verr)
-		default:
-			ErrorResponse(w, r, neterrors.BadRequest(verr.Error()))
-		}
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(200)
-	w.Header().Set("Content-Length", strconv.Itoa(len(response.content)))
-	_, err := w.Write(response.content)
-	if err != nil {
-		logger.Errorf("[nativehandler] response fail url:%v respBytes:%s", r.RequestURI, string(response.content))
-	}
+	fn = h.opts.wrap(fn)
+	writeResult(fullCtx, w, r, response, fn(fullCtx, request, response))
 }
@@ -0,0 +1,84 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/logger"
+	"github.com/vison888/go-vkit/openapi"
+)
+
+const openApiUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<link rel="stylesheet" href="%s/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="%s/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "%s", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>`
+
+// OpenApiHandler 提供*.json文档, 开启HttpOpenApiCdnUI时其余路径返回引用外部swagger-ui的文档页面
+type OpenApiHandler struct {
+	doc   []byte
+	title string
+	opts  HttpOptions
+}
+
+func NewOpenApiHandler(doc *openapi.Document, opts ...HttpOption) *OpenApiHandler {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		logger.Errorf("[gate] openapi marshal fail:%s", err)
+	}
+	return &OpenApiHandler{
+		doc:   b,
+		title: doc.Info.Title,
+		opts:  newHttpOptions(opts...),
+	}
+}
+
+func (h *OpenApiHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if strings.ToUpper(r.Method) != "GET" {
+		ErrorResponse(w, r, neterrors.MethodNotAllowed("method:%s not support, url:%s", r.Method, r.RequestURI))
+		return
+	}
+
+	if h.opts.AuthHandler != nil {
+		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
+			ErrorResponse(w, r, cerr)
+			return
+		}
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.doc)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(h.doc)
+		return
+	}
+
+	if h.opts.OpenApiUICdn == "" {
+		ErrorResponse(w, r, neterrors.NotFound("url:%s not found", r.RequestURI))
+		return
+	}
+	cdn := html.EscapeString(h.opts.OpenApiUICdn)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, openApiUIPage, html.EscapeString(h.title), cdn, cdn, openApiSpecURL(r.URL.Path))
+}
+
+// openApiSpecURL 页面所在目录下的openapi.json, 如/docs/ -> /docs/openapi.json, /api/docs -> /api/openapi.json
+func openApiSpecURL(path string) string {
+	return path[:strings.LastIndex(path, "/")+1] + "openapi.json"
+}
@@ -0,0 +1,53 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vison888/go-vkit/openapi"
+)
+
+func TestOpenApiUI(t *testing.T) {
+	doc := openapi.New(nil, openapi.Title("sso"))
+	h := NewOpenApiHandler(doc, HttpOpenApiCdnUI("/static/swagger-ui/"))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/docs", nil)
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, `"/api/openapi.json"`) || !strings.Contains(body, `"/static/swagger-ui/swagger-ui-bundle.js"`) {
+		t.Fatalf("unexpected page %d %s", w.Code, body)
+	}
+
+	// 未开启页面时只提供json
+	h = NewOpenApiHandler(doc)
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 404 {
+		t.Fatalf("ui should be disabled %d", w.Code)
+	}
+}
+
+func TestOpenApiPaths(t *testing.T) {
+	nh := NewNativeHandler(HttpServiceName("sso"))
+	Handle(nh, "AuthService.Login", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+		resp.Msg = "hello " + req.Name
+		return nil
+	})
+	doc := openapi.New(nh.Endpoints())
+	if len(doc.Paths) != 1 || doc.Paths["/rpc/sso/AuthService.Login"] == nil {
+		t.Fatalf("unexpected paths %v", doc.Paths)
+	}
+
+	// 文档中的路径可直接请求
+	r := httptest.NewRequest(http.MethodPost, "/rpc/sso/AuthService.Login", strings.NewReader(`{"name":"bob"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	nh.Handle(w, r)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "hello bob") {
+		t.Fatalf("unexpected resp %d %s", w.Code, w.Body.String())
+	}
+}
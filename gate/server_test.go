@@ -0,0 +1,30 @@
+package gate
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+type echoReq struct {
+	Name string `json:"name"`
+}
+
+type echoResp struct {
+	Msg string `json:"msg"`
+}
+
+// startEchoServer 启动名为echo的测试服务, 返回监听地址, 测试结束时关闭
+// 监听在Serve前完成, 返回后即可连接
+func startEchoServer(t *testing.T, register func(svr *grpcserver.GrpcServer)) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := grpcserver.NewServer(grpcserver.Name("echo"), grpcserver.GrpcAddr(lis.Addr().String()))
+	register(svr)
+	go svr.Serve(lis)
+	t.Cleanup(svr.Stop)
+	return lis.Addr().String()
+}
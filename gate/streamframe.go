@@ -0,0 +1,245 @@
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/vison888/go-vkit/codec"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// websocket消息帧类型
+const (
+	// 业务数据, 双向
+	FrameTypeData = "data"
+	// 客户端半关闭, 不再发送数据
+	FrameTypeCloseSend = "close_send"
+	// 服务端正常结束流
+	FrameTypeEnd = "end"
+	// 服务端异常结束流
+	FrameTypeError = "error"
+	// 服务端下发会话信息, 用于断线续传
+	FrameTypeSession = "session"
+)
+
+// websocket子协议, 决定消息帧编码
+const (
+	WsSubprotocolJson  = "vkit.json"
+	WsSubprotocolProto = "vkit.proto"
+)
+
+// StreamFrame websocket消息信封
+// seq由发送方从1开始递增编号
+// json编码时data为json, proto编码时data为protobuf, session帧的data固定为json
+//
+// proto编码的信封定义:
+//
+//	message StreamFrame {
+//	  string type = 1;
+//	  int64 seq = 2;
+//	  bytes data = 3;
+//	  NetError error = 4;
+//	}
+//	message NetError {
+//	  int32 code = 1;
+//	  int32 status = 2;
+//	  string msg = 3;
+//	}
+type StreamFrame struct {
+	Type  string              `json:"type"`
+	Seq   int64               `json:"seq"`
+	Data  json.RawMessage     `json:"data,omitempty"`
+	Error *neterrors.NetError `json:"error,omitempty"`
+}
+
+func (f *StreamFrame) isTerminal() bool {
+	return f.Type == FrameTypeEnd || f.Type == FrameTypeError
+}
+
+// frameCodec websocket消息与StreamFrame互转
+type frameCodec interface {
+	// 子协议名
+	Name() string
+	// 转发grpc时使用的content-type
+	ContentType() string
+	Marshal(f *StreamFrame) (int, []byte, error)
+	Unmarshal(mt int, msg []byte) (*StreamFrame, error)
+}
+
+type jsonFrameCodec struct{}
+type protoFrameCodec struct{}
+
+var frameCodecs = map[string]frameCodec{
+	WsSubprotocolJson:  jsonFrameCodec{},
+	WsSubprotocolProto: protoFrameCodec{},
+}
+
+// negotiateFrameCodec 优先按子协议选择帧编码, 其次按content-type, 缺省json
+// 返回值subprotocol表示是否需要在握手响应中确认子协议
+func negotiateFrameCodec(r *http.Request) (fc frameCodec, subprotocol bool, err error) {
+	for _, p := range websocket.Subprotocols(r) {
+		if fc, ok := frameCodecs[p]; ok {
+			return fc, true, nil
+		}
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if index := strings.Index(ct, ";"); index != -1 {
+		ct = ct[:index]
+	}
+	// 浏览器无法为websocket设置content-type
+	if ct == "" {
+		return jsonFrameCodec{}, false, nil
+	}
+
+	cd, ok := codec.DefaultGRPCCodecs[strings.ToLower(ct)]
+	if ok {
+		switch cd.Name() {
+		case "json":
+			return jsonFrameCodec{}, false, nil
+		case "proto":
+			return protoFrameCodec{}, false, nil
+		}
+	}
+	return nil, false, fmt.Errorf("content-type:%s not support", ct)
+}
+
+func decodeStreamFrame(fc frameCodec, mt int, msg []byte) (*StreamFrame, *neterrors.NetError) {
+	frame, err := fc.Unmarshal(mt, msg)
+	if err != nil {
+		return nil, neterrors.BadRequest("[gate] invalid frame:%s", err.Error()).(*neterrors.NetError)
+	}
+
+	switch frame.Type {
+	case FrameTypeData, FrameTypeCloseSend:
+		return frame, nil
+	default:
+		return nil, neterrors.BadRequest("[gate] frame type:%s not support", frame.Type).(*neterrors.NetError)
+	}
+}
+
+func (jsonFrameCodec) Name() string {
+	return WsSubprotocolJson
+}
+
+func (jsonFrameCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonFrameCodec) Marshal(f *StreamFrame) (int, []byte, error) {
+	b, err := json.Marshal(f)
+	return websocket.TextMessage, b, err
+}
+
+func (jsonFrameCodec) Unmarshal(mt int, msg []byte) (*StreamFrame, error) {
+	if mt != websocket.TextMessage {
+		return nil, fmt.Errorf("message type:%d not support", mt)
+	}
+	frame := &StreamFrame{}
+	if err := json.Unmarshal(msg, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (protoFrameCodec) Name() string {
+	return WsSubprotocolProto
+}
+
+func (protoFrameCodec) ContentType() string {
+	return "application/proto"
+}
+
+func (protoFrameCodec) Marshal(f *StreamFrame) (int, []byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, f.Type)
+	if f.Seq != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(f.Seq))
+	}
+	if len(f.Data) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, f.Data)
+	}
+	if f.Error != nil {
+		var e []byte
+		e = protowire.AppendTag(e, 1, protowire.VarintType)
+		e = protowire.AppendVarint(e, uint64(int64(f.Error.Code)))
+		e = protowire.AppendTag(e, 2, protowire.VarintType)
+		e = protowire.AppendVarint(e, uint64(int64(f.Error.Status)))
+		e = protowire.AppendTag(e, 3, protowire.BytesType)
+		e = protowire.AppendString(e, f.Error.Msg)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	}
+	return websocket.BinaryMessage, b, nil
+}
+
+func (protoFrameCodec) Unmarshal(mt int, msg []byte) (*StreamFrame, error) {
+	if mt != websocket.BinaryMessage {
+		return nil, fmt.Errorf("message type:%d not support", mt)
+	}
+
+	frame := &StreamFrame{}
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, m := protowire.ConsumeString(msg)
+			frame.Type, n = v, m
+		case num == 2 && typ == protowire.VarintType:
+			v, m := protowire.ConsumeVarint(msg)
+			frame.Seq, n = int64(v), m
+		case num == 3 && typ == protowire.BytesType:
+			v, m := protowire.ConsumeBytes(msg)
+			frame.Data, n = v, m
+		default:
+			// 上行帧不携带error, 其余字段忽略
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+
+	if frame.Type == "" {
+		return nil, errors.New("frame type is empty")
+	}
+	return frame, nil
+}
+
+// wsCloseCode 将NetError映射为websocket关闭码
+// 正常结束1000, 服务端异常1011, 服务不可用1013, 其余为4000+http状态码
+func wsCloseCode(netErr *neterrors.NetError) int {
+	if netErr == nil {
+		return websocket.CloseNormalClosure
+	}
+	switch netErr.Status {
+	case http.StatusInternalServerError:
+		return websocket.CloseInternalServerErr
+	case http.StatusServiceUnavailable:
+		return websocket.CloseTryAgainLater
+	default:
+		return 4000 + int(netErr.Status)
+	}
+}
+
+func wsCloseMessage(netErr *neterrors.NetError) []byte {
+	text := ""
+	if netErr != nil {
+		text = http.StatusText(int(netErr.Status))
+	}
+	return websocket.FormatCloseMessage(wsCloseCode(netErr), text)
+}
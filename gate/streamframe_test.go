@@ -0,0 +1,50 @@
+package gate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+)
+
+func TestProtoFrameCodec(t *testing.T) {
+	fc := protoFrameCodec{}
+	mt, b, err := fc.Marshal(&StreamFrame{Type: FrameTypeData, Seq: 7, Data: []byte{0x08, 0x01}})
+	if err != nil || mt != websocket.BinaryMessage {
+		t.Fatalf("marshal fail mt:%d err:%v", mt, err)
+	}
+
+	frame, err := fc.Unmarshal(mt, b)
+	if err != nil {
+		t.Fatalf("unmarshal fail %v", err)
+	}
+	if frame.Type != FrameTypeData || frame.Seq != 7 || !bytes.Equal(frame.Data, []byte{0x08, 0x01}) {
+		t.Fatalf("invalid frame %+v", frame)
+	}
+
+	if _, err := fc.Unmarshal(websocket.TextMessage, b); err == nil {
+		t.Fatalf("text message should be rejected")
+	}
+}
+
+func TestWsCloseCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, websocket.CloseNormalClosure},
+		{neterrors.NotFound("not found"), 4404},
+		{neterrors.InternalServerError("panic"), websocket.CloseInternalServerErr},
+		{neterrors.ServiceUnavailable("down"), websocket.CloseTryAgainLater},
+	}
+	for _, tt := range tests {
+		var netErr *neterrors.NetError
+		if tt.err != nil {
+			netErr = tt.err.(*neterrors.NetError)
+		}
+		if code := wsCloseCode(netErr); code != tt.code {
+			t.Fatalf("close code %d != %d", code, tt.code)
+		}
+	}
+}
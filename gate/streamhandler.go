@@ -3,25 +3,48 @@ package gate
 import (
 	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/vison888/go-vkit/codec"
 	"github.com/vison888/go-vkit/errorsx/neterrors"
 	"github.com/vison888/go-vkit/grpcclient"
 	"github.com/vison888/go-vkit/grpcx"
 	"github.com/vison888/go-vkit/logger"
 	meta "github.com/vison888/go-vkit/metadata"
+	"github.com/vison888/go-vkit/utilsx"
 
 	"github.com/gorilla/websocket"
 )
 
 const (
-	DefaultWsPingPeriod     = 60 * time.Second
-	DefaultWsMaxMessageSize = 1024 * 1024 * 4
+	DefaultWsPingPeriod      = 60 * time.Second
+	DefaultWsMaxMessageSize  = 1024 * 1024 * 4
+	DefaultWsReadBufferSize  = 10
+	DefaultWsWriteBufferSize = 10
+	DefaultWsReplaySize      = 64
+
+	// 续传的会话token及最后收到的seq
+	StreamSessionHeader = "X-Stream-Session"
+	StreamLastSeqHeader = "X-Stream-Last-Seq"
+	// 浏览器可通过子协议vkit.session.<token>传递会话token
+	StreamSessionProtocol = "vkit.session."
+)
+
+// WsBackpressure 下行缓冲已满时的处理策略
+type WsBackpressure int
+
+const (
+	// 阻塞grpc读取, 由grpc流控反压到服务端
+	WsBackpressureBlock WsBackpressure = iota
+	// 丢弃数据帧, 客户端可通过seq间隔感知
+	WsBackpressureDrop
+	// 断开慢连接, 开启续传时客户端可重连补发
+	WsBackpressureDisconnect
 )
 
 var (
@@ -40,12 +63,15 @@ var (
 )
 
 type StreamHandler struct {
-	opts HttpOptions
+	opts        HttpOptions
+	sessions    map[string]*StreamContext
+	sessionLock sync.Mutex
 }
 
 func NewStreamHandler(opts ...HttpOption) *StreamHandler {
 	return &StreamHandler{
-		opts: newHttpOptions(opts...),
+		opts:     newHttpOptions(opts...),
+		sessions: make(map[string]*StreamContext),
 	}
 }
 
@@ -55,28 +81,64 @@ func (h *StreamHandler) Init(opts ...HttpOption) {
 	}
 }
 
+// StreamContext 一个grpc流会话, 开启续传时可在断线后被新连接接管
 type StreamContext struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
-	conn      *websocket.Conn
 	stream    grpcx.ClientStream
-	wsReadCh  chan []byte
-	wsWriteCh chan *json.RawMessage
+	token     string
+	// 创建会话的调用方, 续传时校验
+	owner     string
+	codec     frameCodec
+	wsReadCh  chan *StreamFrame
 	closeLock *sync.Mutex
 	isClose   bool
+
+	// 以下字段由closeLock保护
+	conn        *wsConn
+	outbox      []*StreamFrame
+	sendSeq     int64
+	ackSeq      int64
+	trimSeq     int64
+	recvSeq     int64
+	halfClosed  bool
+	spaceCh     chan struct{}
+	resumeTimer *time.Timer
+}
+
+// wsConn 会话当前绑定的websocket连接
+type wsConn struct {
+	conn     *websocket.Conn
+	notifyCh chan struct{}
+	doneCh   chan struct{}
+	once     sync.Once
+	sentSeq  int64
+}
+
+func (c *wsConn) notify() {
+	select {
+	case c.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.doneCh)
+		c.conn.Close()
+	})
 }
 
 func (h *StreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if re := recover(); re != nil {
-			if re := recover(); re != nil {
-				if h.opts.ErrHandler != nil {
-					h.opts.ErrHandler(w, r, re)
-				}
+			if h.opts.ErrHandler != nil {
+				h.opts.ErrHandler(w, r, re)
 			}
 		}
 	}()
 
+	stripHeaders(r, h.opts.TrustedHeaders)
 	// 鉴权
 	if h.opts.AuthHandler != nil {
 		if cerr := h.opts.AuthHandler(w, r); cerr != nil {
@@ -85,28 +147,34 @@ func (h *StreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	conn, err := h.opts.WsUpgrader.Upgrade(w, r, r.Header)
+	fc, subprotocol, err := negotiateFrameCodec(r)
 	if err != nil {
-		logger.Errorf("[gate] StreamHandler Upgrade Err url:%s err:%s", r.RequestURI, err)
+		logger.Errorf("[gate] StreamHandler url:%s %s", r.RequestURI, err)
 		ErrorResponse(w, r, neterrors.Forbidden(err.Error()))
 		return
 	}
-	defer conn.Close()
 
-	readCt := r.Header.Get("Content-Type")
-	index := strings.Index(readCt, ";")
-	if index != -1 {
-		readCt = readCt[:index]
+	var respHeader http.Header
+	if subprotocol {
+		respHeader = http.Header{"Sec-Websocket-Protocol": {fc.Name()}}
 	}
 
-	if strings.ToLower(readCt) != "application/json" {
-		logger.Errorf("[gate] StreamHandler url:%s content-type not application/json", r.RequestURI)
-		ErrorResponse(w, r, neterrors.Forbidden("content-type not application/json"))
+	// 断线重连, token不放在url中, 避免写入访问日志
+	if r.URL.Query().Get("session") != "" {
+		ErrorResponse(w, r, neterrors.BadRequest("[gate] session token should be passed by header %s or subprotocol", StreamSessionHeader))
+		return
+	}
+	if token, byProtocol := sessionToken(r); token != "" {
+		if respHeader == nil && byProtocol {
+			respHeader = http.Header{"Sec-Websocket-Protocol": {StreamSessionProtocol + token}}
+		}
+		h.resume(w, r, token, fc, respHeader)
+		return
 	}
 
 	// 将header转context
 	md := meta.Metadata{}
-	md["x-content-type"] = readCt
+	md["x-content-type"] = fc.ContentType()
 	for k, v := range r.Header {
 		if k == "Connection" {
 			continue
@@ -115,191 +183,388 @@ func (h *StreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := meta.NewContext(context.Background(), md)
 	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
 
 	sc := &StreamContext{
 		ctx:       ctx,
 		ctxCancel: cancel,
-		conn:      conn,
-		wsReadCh:  make(chan []byte, 10),
-		wsWriteCh: make(chan *json.RawMessage, 10),
+		token:     utilsx.GenUuid(),
+		owner:     h.sessionOwner(r),
+		codec:     fc,
+		wsReadCh:  make(chan *StreamFrame, h.opts.WsReadBufferSize),
 		closeLock: new(sync.Mutex),
 		isClose:   false,
+		spaceCh:   make(chan struct{}),
 	}
 
-	if err = h.connectGrpcServer(sc, w, r); err != nil {
+	// 先建立grpc流, 失败时仍可返回http错误
+	if err := h.connectGrpcServer(sc, r); err != nil {
+		logger.Errorf("[gate] StreamHandler connectGrpcServer url:%s err:%s", r.RequestURI, err)
+		cancel()
+		ErrorResponse(w, r, err)
 		return
 	}
-	h.readsAndWrites(sc)
+
+	// Upgrade失败时已自行响应http错误
+	conn, err := h.opts.WsUpgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		logger.Errorf("[gate] StreamHandler Upgrade Err url:%s err:%s", r.RequestURI, err)
+		cancel()
+		sc.stream.Close()
+		return
+	}
+
+	if h.opts.WsResumeWindow > 0 {
+		h.sessionLock.Lock()
+		h.sessions[sc.token] = sc
+		h.sessionLock.Unlock()
+	}
+
+	go h.grpcRead(sc)
+	go h.grpcWrite(sc)
+	h.attach(sc, conn, 0)
 }
 
-func (h *StreamHandler) Close(sc *StreamContext) {
-	if sc.isClose {
+// resume 新连接接管未过期的会话, 补发last_seq之后的帧
+func (h *StreamHandler) resume(w http.ResponseWriter, r *http.Request, token string, fc frameCodec, respHeader http.Header) {
+	if h.opts.WsResumeWindow <= 0 {
+		ErrorResponse(w, r, neterrors.BadRequest("[gate] session resume disabled"))
 		return
 	}
 
+	var lastSeq int64
+	v := r.Header.Get(StreamLastSeqHeader)
+	if v == "" {
+		v = r.URL.Query().Get("last_seq")
+	}
+	if v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			ErrorResponse(w, r, neterrors.BadRequest("[gate] invalid last_seq:%s", v))
+			return
+		}
+		lastSeq = n
+	}
+
+	h.sessionLock.Lock()
+	sc, ok := h.sessions[token]
+	h.sessionLock.Unlock()
+	if !ok {
+		ErrorResponse(w, r, neterrors.NotFound("[gate] session not found"))
+		return
+	}
+
+	if sc.owner != h.sessionOwner(r) {
+		logger.Errorf("[gate] StreamHandler session:%s owner mismatch url:%s", token, r.URL.Path)
+		ErrorResponse(w, r, neterrors.Forbidden("[gate] session owner mismatch"))
+		return
+	}
+
+	if sc.codec.Name() != fc.Name() {
+		ErrorResponse(w, r, neterrors.BadRequest("[gate] session subprotocol:%s mismatch", sc.codec.Name()))
+		return
+	}
+
+	conn, err := h.opts.WsUpgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		logger.Errorf("[gate] StreamHandler Upgrade Err url:%s err:%s", r.RequestURI, err)
+		return
+	}
+
+	logger.Infof("[gate] StreamHandler session:%s resume last_seq:%d", token, lastSeq)
+	h.attach(sc, conn, lastSeq)
+}
+
+func (h *StreamHandler) sessionOwner(r *http.Request) string {
+	if h.opts.WsSessionOwner != nil {
+		return h.opts.WsSessionOwner(r)
+	}
+	return credentialDigest(r)
+}
+
+// sessionToken 续传token取自请求头, 浏览器无法设置请求头时取自子协议
+func sessionToken(r *http.Request) (token string, byProtocol bool) {
+	if token = r.Header.Get(StreamSessionHeader); token != "" {
+		return token, false
+	}
+	for _, p := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(p, StreamSessionProtocol) {
+			return strings.TrimPrefix(p, StreamSessionProtocol), true
+		}
+	}
+	return "", false
+}
+
+func (h *StreamHandler) Close(sc *StreamContext) {
 	sc.closeLock.Lock()
-	defer sc.closeLock.Unlock()
 	if sc.isClose {
+		sc.closeLock.Unlock()
 		return
 	}
 	sc.isClose = true
+	conn := sc.conn
+	sc.conn = nil
+	if sc.resumeTimer != nil {
+		sc.resumeTimer.Stop()
+		sc.resumeTimer = nil
+	}
+	sc.closeLock.Unlock()
+
+	h.sessionLock.Lock()
+	delete(h.sessions, sc.token)
+	h.sessionLock.Unlock()
 
 	sc.ctxCancel()
-	sc.conn.Close()
+	if conn != nil {
+		conn.close()
+	}
 	sc.stream.Close()
 }
 
-func (h *StreamHandler) connectGrpcServer(sc *StreamContext, w http.ResponseWriter, r *http.Request) error {
+func (h *StreamHandler) connectGrpcServer(sc *StreamContext, r *http.Request) error {
 	var service, endpoint string
-	path := strings.Split(r.RequestURI, "/")
+	path := strings.Split(r.URL.Path, "/")
 	if len(path) > 3 {
 		service = path[2]
 		endpoint = path[3]
 	}
 
 	if len(service) == 0 {
-		return errors.New("service is empty")
+		return neterrors.BadRequest("[gate] service is empty url:%s", r.RequestURI)
 	}
 
 	if len(endpoint) == 0 {
-		return errors.New("endpoint is empty")
+		return neterrors.BadRequest("[gate] endpoint is empty url:%s", r.RequestURI)
 	}
 
 	// 连接grpc服务
-	target := fmt.Sprintf("%s:%d", service, h.opts.GrpcPort)
+	target := h.opts.route(sc.ctx, service, r)
 	stream, netErr := grpcclient.StreamByGate(sc.ctx, target, service, endpoint)
 	if netErr != nil {
-		return fmt.Errorf("StreamByGate fail:%s", netErr.Error())
+		return netErr
 	}
 	sc.stream = stream
 	return nil
 }
 
-func (h *StreamHandler) readsAndWrites(sc *StreamContext) {
-	stopWsCtx, wsCancel := context.WithCancel(context.Background())
-	stopGrpcCtx, grpcCancel := context.WithCancel(context.Background())
+// attach 将websocket连接绑定到会话, 阻塞至连接断开
+func (h *StreamHandler) attach(sc *StreamContext, conn *websocket.Conn, lastSeq int64) {
+	c := &wsConn{
+		conn:     conn,
+		notifyCh: make(chan struct{}, 1),
+		doneCh:   make(chan struct{}),
+		sentSeq:  lastSeq,
+	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(4)
+	sc.closeLock.Lock()
+	if sc.isClose {
+		sc.closeLock.Unlock()
+		h.closeConn(sc, c, neterrors.NotFound("[gate] session closed").(*neterrors.NetError))
+		return
+	}
+	if lastSeq < sc.trimSeq || lastSeq > sc.sendSeq {
+		sc.closeLock.Unlock()
+		h.closeConn(sc, c, neterrors.BadRequest("[gate] last_seq:%d out of replay window", lastSeq).(*neterrors.NetError))
+		h.Close(sc)
+		return
+	}
+	old := sc.conn
+	sc.conn = c
+	if sc.resumeTimer != nil {
+		sc.resumeTimer.Stop()
+		sc.resumeTimer = nil
+	}
+	sc.closeLock.Unlock()
 
-	go h.wsRead(stopWsCtx, wsCancel, &wg, sc)
-	go h.wsWrite(stopWsCtx, wsCancel, &wg, sc)
-	go h.grpcRead(stopGrpcCtx, grpcCancel, &wg, sc)
-	go h.grpcWrite(stopGrpcCtx, grpcCancel, &wg, sc)
+	// 同一会话只保留最新连接
+	if old != nil {
+		old.close()
+	}
+	// 触发补发
+	c.notify()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go h.wsRead(&wg, sc, c)
+	go h.wsWrite(&wg, sc, c)
 	wg.Wait()
 }
 
-func (h *StreamHandler) wsRead(stopCtx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, sc *StreamContext) {
+// finish 投递结束帧, 由wsWrite写出后关闭会话
+func (h *StreamHandler) finish(sc *StreamContext, netErr *neterrors.NetError) {
+	frame := &StreamFrame{Type: FrameTypeEnd}
+	if netErr != nil {
+		frame = &StreamFrame{Type: FrameTypeError, Error: netErr}
+	}
+	h.push(sc, frame)
+}
+
+func (h *StreamHandler) writeFrame(sc *StreamContext, c *wsConn, frame *StreamFrame) error {
+	mt, respBytes, err := sc.codec.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(mt, respBytes)
+}
+
+// closeConn 向未绑定会话的连接写出错误帧并关闭
+func (h *StreamHandler) closeConn(sc *StreamContext, c *wsConn, netErr *neterrors.NetError) {
+	if err := h.writeFrame(sc, c, &StreamFrame{Type: FrameTypeError, Error: netErr}); err == nil {
+		c.conn.WriteControl(websocket.CloseMessage, wsCloseMessage(netErr), time.Now().Add(time.Second))
+	}
+	c.close()
+}
+
+func (h *StreamHandler) wsRead(wg *sync.WaitGroup, sc *StreamContext, c *wsConn) {
 	defer func() {
-		cancel()
 		wg.Done()
-		h.Close(sc)
 		logger.Info("defer wsRead")
 	}()
-	sc.conn.SetReadLimit(int64(h.opts.WsMaxMessageSize))
+	c.conn.SetReadLimit(int64(h.opts.WsMaxMessageSize))
 
 	for {
-		select {
-		case <-stopCtx.Done():
+		mt, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Errorf("[gate] StreamHandler wsRead err: %+v", err)
+			}
+			h.detach(sc, c)
 			return
-		default:
 		}
 
-		mt, msg, err := sc.conn.ReadMessage()
-		if err != nil {
-			logger.Errorf("[gate] StreamHandler wsRead err: %+v", err)
+		frame, netErr := decodeStreamFrame(sc.codec, mt, msg)
+		if netErr == nil {
+			sc.closeLock.Lock()
+			// 重连后客户端重发的帧
+			dup := frame.Seq > 0 && frame.Seq <= sc.recvSeq
+			if !dup {
+				if sc.halfClosed {
+					netErr = neterrors.BadRequest("[gate] frame after close_send").(*neterrors.NetError)
+				} else {
+					if frame.Seq > 0 {
+						sc.recvSeq = frame.Seq
+					}
+					sc.halfClosed = frame.Type == FrameTypeCloseSend
+				}
+			}
+			sc.closeLock.Unlock()
+			if dup {
+				continue
+			}
+		}
+		if netErr != nil {
+			logger.Errorf("[gate] StreamHandler wsRead err: %s", netErr.Msg)
+			h.finish(sc, netErr)
 			return
 		}
 
-		if mt == websocket.BinaryMessage {
-			logger.Errorf("[gate] StreamHandler wsRead not support websocket.BinaryMessage mt: %d", mt)
+		select {
+		case sc.wsReadCh <- frame:
+		case <-sc.ctx.Done():
 			return
 		}
-		sc.wsReadCh <- msg
 	}
 }
 
-func (h *StreamHandler) wsWrite(stopCtx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, sc *StreamContext) {
+func (h *StreamHandler) wsWrite(wg *sync.WaitGroup, sc *StreamContext, c *wsConn) {
 	defer func() {
-		cancel()
 		wg.Done()
-		h.Close(sc)
+		h.detach(sc, c)
 		logger.Info("defer wsWrite")
 	}()
+
+	// 告知客户端会话token及已收到的上行seq
+	if h.opts.WsResumeWindow > 0 {
+		sc.closeLock.Lock()
+		data, _ := json.Marshal(&streamSession{Token: sc.token, Ack: sc.recvSeq})
+		sc.closeLock.Unlock()
+		if err := h.writeFrame(sc, c, &StreamFrame{Type: FrameTypeSession, Data: data}); err != nil {
+			logger.Errorf("[gate] StreamHandler wsWrite conn.WriteMessage err: %+v", err)
+			return
+		}
+	}
+
 	ticker := time.NewTicker(h.opts.WsPingPeriod)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-stopCtx.Done():
+		case <-c.doneCh:
 			return
 		case <-sc.ctx.Done():
 			return
-		case <-sc.stream.Context().Done():
-			return
 		case <-ticker.C:
-			if err := sc.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				logger.Errorf("[gate] StreamHandler wsWrite conn.WriteMessage err: %+v", err)
 				return
 			}
-		case msg := <-sc.wsWriteCh:
-			respBytes, err := msg.MarshalJSON()
-			if err != nil {
-				logger.Errorf("[gate] StreamHandler wsWrite msg.MarshalJSON err: %+v", err)
+			continue
+		case <-c.notifyCh:
+		}
+
+		for frame := h.nextFrame(sc, c); frame != nil; frame = h.nextFrame(sc, c) {
+			if err := h.writeFrame(sc, c, frame); err != nil {
+				logger.Errorf("[gate] StreamHandler wsWrite conn.WriteMessage err: %+v", err)
 				return
 			}
+			h.ackFrame(sc, c, frame.Seq)
 
-			if err := sc.conn.WriteMessage(websocket.TextMessage, respBytes); err != nil {
-				logger.Errorf("[gate] StreamHandler wsWrite conn.WriteMessage err: %+v", err)
+			if frame.isTerminal() {
+				deadline := time.Now().Add(time.Second)
+				if err := c.conn.WriteControl(websocket.CloseMessage, wsCloseMessage(frame.Error), deadline); err != nil {
+					logger.Errorf("[gate] StreamHandler wsWrite close err: %+v", err)
+				}
+				h.Close(sc)
 				return
 			}
 		}
 	}
 }
 
-func (h *StreamHandler) grpcWrite(stopCtx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, sc *StreamContext) {
-	defer func() {
-		cancel()
-		wg.Done()
-		h.Close(sc)
-		logger.Info("defer grpcWrite")
-	}()
+func (h *StreamHandler) grpcWrite(sc *StreamContext) {
+	defer logger.Info("defer grpcWrite")
 
 	for {
 		select {
-		case <-stopCtx.Done():
+		case <-sc.ctx.Done():
 			return
-		case msg := <-sc.wsReadCh:
-			if err := sc.stream.Send(msg); err != nil {
-				logger.Errorf("[gate] StreamHandler grpcWrite stream.Send err: %+v", err)
+		case frame := <-sc.wsReadCh:
+			if frame.Type == FrameTypeCloseSend {
+				if err := grpcx.CloseSend(sc.stream); err != nil {
+					logger.Errorf("[gate] StreamHandler grpcWrite stream.CloseSend err: %+v", err)
+					h.finish(sc, grpcclient.ToNetError(err))
+				}
+				return
+			}
+
+			if err := sc.stream.Send(&codec.Frame{Data: frame.Data}); err != nil {
+				// io.EOF表示服务端已结束, 真实状态由grpcRead取得
+				if err != io.EOF {
+					logger.Errorf("[gate] StreamHandler grpcWrite stream.Send err: %+v", err)
+					h.finish(sc, grpcclient.ToNetError(err))
+				}
 				return
 			}
 		}
 	}
 }
 
-func (h *StreamHandler) grpcRead(stopCtx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, sc *StreamContext) {
-	defer func() {
-		cancel()
-		wg.Done()
-		h.Close(sc)
-		logger.Info("defer grpcRead")
-	}()
+func (h *StreamHandler) grpcRead(sc *StreamContext) {
+	defer logger.Info("defer grpcRead")
 
 	for {
-		select {
-		case <-stopCtx.Done():
+		data := &codec.Frame{}
+		err := sc.stream.Recv(data)
+		if err == io.EOF {
+			h.finish(sc, nil)
 			return
-		default:
 		}
-
-		data := &json.RawMessage{}
-		err := sc.stream.Recv(data)
 		if err != nil {
-			logger.Errorf("[gate] StreamHandler grpcRead stream.Recv err: %+v", err)
+			if sc.ctx.Err() == nil {
+				logger.Errorf("[gate] StreamHandler grpcRead stream.Recv err: %+v", err)
+				h.finish(sc, grpcclient.ToNetError(err))
+			}
 			return
 		}
-		sc.wsWriteCh <- data
-	}
 
+		h.push(sc, &StreamFrame{Type: FrameTypeData, Data: data.Data})
+	}
 }
@@ -0,0 +1,150 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vison888/go-vkit/logger"
+)
+
+// streamSession 会话帧数据, 客户端断线后携带token与last_seq重连
+// ack为网关已收到的上行seq, 客户端从ack+1开始重发
+type streamSession struct {
+	Token string `json:"token"`
+	Ack   int64  `json:"ack"`
+}
+
+// push 投递下行帧, 缓冲已满时按WsBackpressure处理, 结束帧不受缓冲限制
+func (h *StreamHandler) push(sc *StreamContext, frame *StreamFrame) {
+	for {
+		sc.closeLock.Lock()
+		if sc.isClose {
+			sc.closeLock.Unlock()
+			return
+		}
+
+		if frame.isTerminal() || sc.pendingCount() < h.opts.WsWriteBufferSize {
+			sc.sendSeq++
+			frame.Seq = sc.sendSeq
+			sc.outbox = append(sc.outbox, frame)
+			if sc.conn != nil {
+				sc.conn.notify()
+			}
+			sc.closeLock.Unlock()
+			return
+		}
+
+		if h.opts.WsBackpressure == WsBackpressureDrop {
+			// 占用序号, 客户端据此感知丢帧
+			sc.sendSeq++
+			seq := sc.sendSeq
+			sc.closeLock.Unlock()
+			logger.Errorf("[gate] StreamHandler session:%s drop frame seq:%d", sc.token, seq)
+			return
+		}
+
+		var slow *wsConn
+		if h.opts.WsBackpressure == WsBackpressureDisconnect {
+			slow = sc.conn
+		}
+		spaceCh := sc.spaceCh
+		sc.closeLock.Unlock()
+
+		if slow != nil {
+			logger.Errorf("[gate] StreamHandler session:%s disconnect slow consumer", sc.token)
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+			slow.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			h.detach(sc, slow)
+		}
+
+		select {
+		case <-spaceCh:
+		case <-sc.ctx.Done():
+			return
+		}
+	}
+}
+
+// pendingCount 未写出的帧数, 调用方需持有closeLock
+func (sc *StreamContext) pendingCount() int {
+	n := 0
+	for i := len(sc.outbox) - 1; i >= 0 && sc.outbox[i].Seq > sc.ackSeq; i-- {
+		n++
+	}
+	return n
+}
+
+// nextFrame 取连接下一个待写出的帧
+func (h *StreamHandler) nextFrame(sc *StreamContext, c *wsConn) *StreamFrame {
+	sc.closeLock.Lock()
+	defer sc.closeLock.Unlock()
+	if sc.conn != c {
+		return nil
+	}
+	for _, frame := range sc.outbox {
+		if frame.Seq > c.sentSeq {
+			return frame
+		}
+	}
+	return nil
+}
+
+// ackFrame 记录已写出的帧, 仅保留WsReplaySize个已写出的帧用于续传
+func (h *StreamHandler) ackFrame(sc *StreamContext, c *wsConn, seq int64) {
+	sc.closeLock.Lock()
+	defer sc.closeLock.Unlock()
+
+	c.sentSeq = seq
+	if seq > sc.ackSeq {
+		sc.ackSeq = seq
+	}
+
+	keep := 0
+	if h.opts.WsResumeWindow > 0 {
+		keep = h.opts.WsReplaySize
+	}
+	sent := len(sc.outbox) - sc.pendingCount()
+	if sent > keep {
+		n := sent - keep
+		sc.trimSeq = sc.outbox[n-1].Seq
+		sc.outbox = append(sc.outbox[:0], sc.outbox[n:]...)
+	}
+
+	// 唤醒等待缓冲的生产者
+	close(sc.spaceCh)
+	sc.spaceCh = make(chan struct{})
+}
+
+// detach 解除连接与会话的绑定, 开启续传时会话保留WsResumeWindow等待重连
+func (h *StreamHandler) detach(sc *StreamContext, c *wsConn) {
+	c.close()
+
+	sc.closeLock.Lock()
+	if sc.conn != c || sc.isClose {
+		sc.closeLock.Unlock()
+		return
+	}
+	sc.conn = nil
+	resumable := h.opts.WsResumeWindow > 0
+	if resumable {
+		sc.resumeTimer = time.AfterFunc(h.opts.WsResumeWindow, func() {
+			h.expire(sc)
+		})
+	}
+	sc.closeLock.Unlock()
+
+	if !resumable {
+		h.Close(sc)
+	}
+}
+
+func (h *StreamHandler) expire(sc *StreamContext) {
+	sc.closeLock.Lock()
+	reattached := sc.conn != nil
+	sc.closeLock.Unlock()
+
+	if !reattached {
+		logger.Infof("[gate] StreamHandler session:%s expired", sc.token)
+		h.Close(sc)
+	}
+}
@@ -0,0 +1,96 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStreamContext() *StreamContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StreamContext{
+		ctx:       ctx,
+		ctxCancel: cancel,
+		closeLock: new(sync.Mutex),
+		spaceCh:   make(chan struct{}),
+	}
+}
+
+func TestStreamPushDrop(t *testing.T) {
+	h := NewStreamHandler(WsBufferSize(2, 2), WsBackpressurePolicy(WsBackpressureDrop))
+	sc := newTestStreamContext()
+	for i := 0; i < 4; i++ {
+		h.push(sc, &StreamFrame{Type: FrameTypeData})
+	}
+	h.finish(sc, nil)
+
+	if len(sc.outbox) != 3 {
+		t.Fatalf("outbox len %d != 3", len(sc.outbox))
+	}
+	if seq := sc.outbox[2].Seq; seq != 5 {
+		t.Fatalf("end frame seq %d != 5", seq)
+	}
+}
+
+func TestStreamReplayWindow(t *testing.T) {
+	h := NewStreamHandler(WsBufferSize(10, 10), WsResume(time.Minute, 2))
+	sc := newTestStreamContext()
+	c := &wsConn{notifyCh: make(chan struct{}, 1), doneCh: make(chan struct{})}
+	sc.conn = c
+
+	for i := 0; i < 5; i++ {
+		h.push(sc, &StreamFrame{Type: FrameTypeData})
+	}
+	for frame := h.nextFrame(sc, c); frame != nil; frame = h.nextFrame(sc, c) {
+		h.ackFrame(sc, c, frame.Seq)
+	}
+
+	if sc.trimSeq != 3 {
+		t.Fatalf("trimSeq %d != 3", sc.trimSeq)
+	}
+
+	// 客户端只收到了seq 3, 重连后从seq 4补发
+	resumed := &wsConn{notifyCh: make(chan struct{}, 1), doneCh: make(chan struct{}), sentSeq: 3}
+	sc.conn = resumed
+	if frame := h.nextFrame(sc, resumed); frame == nil || frame.Seq != 4 {
+		t.Fatalf("replay frame %+v want seq 4", frame)
+	}
+}
+
+func TestStreamResumeOwner(t *testing.T) {
+	h := NewStreamHandler(WsResume(time.Minute, 2))
+	sc := newTestStreamContext()
+	sc.token, sc.codec = "t1", jsonFrameCodec{}
+	r := httptest.NewRequest(http.MethodGet, "/ws/echo/EchoService.Count", nil)
+	r.Header.Set("Authorization", "Bearer u1")
+	sc.owner = h.sessionOwner(r)
+	h.sessions[sc.token] = sc
+
+	// 其他调用方持有token也不能接管会话
+	r = httptest.NewRequest(http.MethodGet, "/ws/echo/EchoService.Count", nil)
+	r.Header.Set("Authorization", "Bearer u2")
+	r.Header.Set(StreamSessionHeader, "t1")
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("resume by other owner should be forbidden %d %s", w.Code, w.Body.String())
+	}
+
+	// token不接受url传递
+	r = httptest.NewRequest(http.MethodGet, "/ws/echo/EchoService.Count?session=t1", nil)
+	r.Header.Set("Authorization", "Bearer u1")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("session in url should be rejected %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws/echo/EchoService.Count", nil)
+	r.Header.Set("Sec-Websocket-Protocol", "vkit.json, "+StreamSessionProtocol+"t1")
+	if token, byProtocol := sessionToken(r); token != "t1" || !byProtocol {
+		t.Fatalf("session token from subprotocol %s", token)
+	}
+}
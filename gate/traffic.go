@@ -0,0 +1,123 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// TrafficPolicy 服务的流量划分, 用于灰度发布
+// 先按Overrides匹配请求头或metadata, 再按权重百分比在Backends间划分, 都未命中时使用默认地址
+type TrafficPolicy struct {
+	Backends  []*TrafficBackend  `json:"backends" yaml:"backends"`
+	Overrides []*TrafficOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+	// 粘性键, 请求头或metadata名, 如x-user-id, 同一取值固定路由到同一版本
+	StickyKey string `json:"stickyKey,omitempty" yaml:"stickyKey,omitempty"`
+}
+
+// TrafficBackend 服务的一个版本
+type TrafficBackend struct {
+	Name string `json:"name" yaml:"name"`
+	// grpc地址, 多个时轮询
+	Addrs []string `json:"addrs" yaml:"addrs"`
+	// 流量百分比, 各版本之和不超过100, 剩余流量使用默认地址
+	// 如只配置canary 5时95%的流量仍走默认地址, 为0时只接收Overrides命中的流量
+	Weight int `json:"weight" yaml:"weight"`
+
+	next uint32
+}
+
+// TrafficOverride 请求头或metadata匹配时路由到指定版本, 如x-canary: true
+type TrafficOverride struct {
+	Key string `json:"key" yaml:"key"`
+	// 为空时只要求存在
+	Value   string `json:"value,omitempty" yaml:"value,omitempty"`
+	Backend string `json:"backend" yaml:"backend"`
+}
+
+func (p *TrafficPolicy) Validate() error {
+	names := make(map[string]bool)
+	total := 0
+	for _, b := range p.Backends {
+		if b.Name == "" || len(b.Addrs) == 0 {
+			return fmt.Errorf("traffic backend name and addrs are required")
+		}
+		if names[b.Name] {
+			return fmt.Errorf("traffic backend %s duplicated", b.Name)
+		}
+		if b.Weight < 0 {
+			return fmt.Errorf("traffic backend %s: weight should not be negative", b.Name)
+		}
+		names[b.Name] = true
+		total += b.Weight
+	}
+	if total > 100 {
+		return fmt.Errorf("traffic backend weights sum %d exceeds 100", total)
+	}
+	for _, o := range p.Overrides {
+		if o.Key == "" {
+			return fmt.Errorf("traffic override key is required")
+		}
+		if !names[o.Backend] {
+			return fmt.Errorf("traffic override %s: backend %s not found", o.Key, o.Backend)
+		}
+	}
+	return nil
+}
+
+func (p *TrafficPolicy) backend(name string) *TrafficBackend {
+	for _, b := range p.Backends {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// pick 返回选中的地址, 未命中时返回空
+func (p *TrafficPolicy) pick(ctx context.Context, r *http.Request) string {
+	for _, o := range p.Overrides {
+		v, ok := requestValue(ctx, r, o.Key)
+		if ok && (o.Value == "" || strings.EqualFold(o.Value, v)) {
+			return p.backend(o.Backend).addr("")
+		}
+	}
+
+	sticky := ""
+	if p.StickyKey != "" {
+		sticky, _ = requestValue(ctx, r, p.StickyKey)
+	}
+	var n int
+	if sticky != "" {
+		n = int(hash32(sticky) % 100)
+	} else {
+		n = rand.Intn(100)
+	}
+	for _, b := range p.Backends {
+		if n < b.Weight {
+			return b.addr(sticky)
+		}
+		n -= b.Weight
+	}
+	return ""
+}
+
+func (b *TrafficBackend) addr(sticky string) string {
+	if len(b.Addrs) == 1 {
+		return b.Addrs[0]
+	}
+	if sticky != "" {
+		return b.Addrs[hash32(b.Name+sticky)%uint32(len(b.Addrs))]
+	}
+	return b.Addrs[atomic.AddUint32(&b.next, 1)%uint32(len(b.Addrs))]
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,101 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	meta "github.com/vison888/go-vkit/metadata"
+)
+
+func TestTrafficPolicy(t *testing.T) {
+	p := &TrafficPolicy{
+		Backends: []*TrafficBackend{
+			{Name: "stable", Addrs: []string{"v1:10000"}, Weight: 95},
+			{Name: "canary", Addrs: []string{"v2:10000"}, Weight: 5},
+			{Name: "beta", Addrs: []string{"v3-a:10000", "v3-b:10000"}},
+		},
+		Overrides: []*TrafficOverride{
+			{Key: "x-canary", Value: "true", Backend: "canary"},
+			{Key: "x-beta", Backend: "beta"},
+		},
+		StickyKey: "x-user-id",
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	o := newHttpOptions(HttpGrpcPort(10000), HttpTraffic("order", p))
+	ctx := context.Background()
+
+	newReq := func(hdr map[string]string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/rpc/order/OrderService.Get", nil)
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	// 按权重划分
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[o.route(ctx, "order", newReq(nil))]++
+	}
+	if counts["v2:10000"] < 300 || counts["v2:10000"] > 700 || counts["v1:10000"]+counts["v2:10000"] != 10000 {
+		t.Fatalf("unexpected split %v", counts)
+	}
+
+	// 同一用户固定路由
+	for i := 0; i < 20; i++ {
+		user := map[string]string{"x-user-id": fmt.Sprintf("u%d", i)}
+		first := o.route(ctx, "order", newReq(user))
+		for j := 0; j < 5; j++ {
+			if addr := o.route(ctx, "order", newReq(user)); addr != first {
+				t.Fatalf("sticky route changed %s != %s", addr, first)
+			}
+		}
+	}
+
+	// 请求头及metadata覆盖
+	if addr := o.route(ctx, "order", newReq(map[string]string{"x-canary": "true"})); addr != "v2:10000" {
+		t.Fatalf("header override fail %s", addr)
+	}
+	mdCtx := meta.NewContext(ctx, meta.Metadata{"x-beta": "1"})
+	if addr := o.route(mdCtx, "order", newReq(nil)); addr != "v3-a:10000" && addr != "v3-b:10000" {
+		t.Fatalf("metadata override fail %s", addr)
+	}
+
+	// 其他服务使用默认地址
+	if addr := o.route(ctx, "user", newReq(nil)); addr != "user:10000" {
+		t.Fatalf("default target fail %s", addr)
+	}
+
+	// 权重为百分比, 剩余流量使用默认地址
+	o = newHttpOptions(HttpGrpcPort(10000), HttpTraffic("order", &TrafficPolicy{
+		Backends: []*TrafficBackend{{Name: "canary", Addrs: []string{"v2:10000"}, Weight: 5}},
+	}))
+	counts = make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[o.route(ctx, "order", newReq(nil))]++
+	}
+	if counts["v2:10000"] < 300 || counts["v2:10000"] > 700 || counts["order:10000"]+counts["v2:10000"] != 10000 {
+		t.Fatalf("remaining traffic should use default addr %v", counts)
+	}
+
+	over := &TrafficPolicy{Backends: []*TrafficBackend{
+		{Name: "a", Addrs: []string{"a:1"}, Weight: 60},
+		{Name: "b", Addrs: []string{"b:1"}, Weight: 50},
+	}}
+	if err := over.Validate(); err == nil {
+		t.Fatal("weights over 100 should fail")
+	}
+
+	bad := &TrafficPolicy{
+		Backends:  []*TrafficBackend{{Name: "a", Addrs: []string{"a:1"}, Weight: 1}},
+		Overrides: []*TrafficOverride{{Key: "x-canary", Backend: "b"}},
+	}
+	if err := bad.Validate(); err == nil {
+		t.Fatal("unknown backend should fail")
+	}
+}
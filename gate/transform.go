@@ -0,0 +1,280 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+)
+
+const (
+	InjectTypeString = "string"
+	InjectTypeInt    = "int"
+	InjectTypeBool   = "bool"
+)
+
+// TransformConfig 路由的请求及响应转换, 用于兼容旧客户端, 仅支持json
+// 请求依次执行Rename、Drop、Inject, 响应依次执行Rename、Drop、Envelope
+type TransformConfig struct {
+	Request  *BodyTransform `json:"request,omitempty" yaml:"request,omitempty"`
+	Response *BodyTransform `json:"response,omitempty" yaml:"response,omitempty"`
+	// 将metadata注入请求体, 覆盖客户端传入的同名字段
+	Inject []*InjectConfig `json:"inject,omitempty" yaml:"inject,omitempty"`
+	// 成功响应的外层包装
+	Envelope *EnvelopeConfig `json:"envelope,omitempty" yaml:"envelope,omitempty"`
+}
+
+// BodyTransform 字段路径以.分隔嵌套对象, 如user.name
+type BodyTransform struct {
+	// 旧字段路径 -> 新字段路径
+	Rename map[string]string `json:"rename,omitempty" yaml:"rename,omitempty"`
+	Drop   []string          `json:"drop,omitempty" yaml:"drop,omitempty"`
+}
+
+type InjectConfig struct {
+	// 请求体字段路径
+	Field string `json:"field" yaml:"field"`
+	// metadata或请求头名, 如x-user-id, 由AuthHandler设置, 不存在时删除该字段
+	// 客户端传入的同名请求头在鉴权前被删除, 避免伪造
+	Key string `json:"key" yaml:"key"`
+	// string(缺省)、int、bool
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+// EnvelopeConfig 如Field为data, Extra为{"code":0}时响应为{"code":0,"data":<原响应>}
+type EnvelopeConfig struct {
+	Field string         `json:"field" yaml:"field"`
+	Extra map[string]any `json:"extra,omitempty" yaml:"extra,omitempty"`
+}
+
+func (c *TransformConfig) Validate() error {
+	for _, bt := range []*BodyTransform{c.Request, c.Response} {
+		if bt == nil {
+			continue
+		}
+		for from, to := range bt.Rename {
+			if from == "" || to == "" {
+				return fmt.Errorf("transform rename field is required")
+			}
+		}
+	}
+	for _, in := range c.Inject {
+		if in.Field == "" || in.Key == "" {
+			return fmt.Errorf("transform inject field and key are required")
+		}
+		switch in.Type {
+		case "", InjectTypeString, InjectTypeInt, InjectTypeBool:
+		default:
+			return fmt.Errorf("transform inject %s: type %s not support", in.Field, in.Type)
+		}
+	}
+	if c.Envelope != nil && c.Envelope.Field == "" {
+		return fmt.Errorf("transform envelope field is required")
+	}
+	return nil
+}
+
+// TransformWrapper 用于GrpcHandler的HttpWrapHandler, 按配置转换请求及成功响应
+// 使用Inject时应通过HttpTransform添加, 或用HttpTrustedHeaders保护注入的请求头
+func TransformWrapper(cfg *TransformConfig) HandlerWrapper {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *HttpRequest, resp *HttpResponse) error {
+			if cfg.Request != nil || len(cfg.Inject) > 0 {
+				body, _, err := req.Read()
+				if err != nil {
+					return neterrors.BadRequest("[gate] %s url:%s", err.Error(), req.Uri())
+				}
+				body, err = cfg.transformRequest(ctx, req.Request(), body)
+				if err != nil {
+					return neterrors.BadRequest("[gate] transform request fail:%s url:%s", err.Error(), req.Uri())
+				}
+				req.SetBody(body)
+			}
+
+			if appErr := next(ctx, req, resp); appErr != nil || resp.hasWrite {
+				return appErr
+			}
+
+			if cfg.Response != nil || cfg.Envelope != nil {
+				content, err := cfg.transformResponse(resp.content)
+				if err != nil {
+					return neterrors.BadGateway("[gate] transform response fail:%s url:%s", err.Error(), req.Uri())
+				}
+				resp.content = content
+			}
+			return nil
+		}
+	}
+}
+
+func (c *TransformConfig) transformRequest(ctx context.Context, r *http.Request, body []byte) ([]byte, error) {
+	obj, err := decodeObject(body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.apply(obj)
+	for _, in := range c.Inject {
+		v, ok := requestValue(ctx, r, in.Key)
+		if !ok {
+			deletePath(obj, in.Field)
+			continue
+		}
+		typed, err := injectValue(v, in.Type)
+		if err != nil {
+			return nil, fmt.Errorf("inject %s: %w", in.Field, err)
+		}
+		setPath(obj, in.Field, typed)
+	}
+	return json.Marshal(obj)
+}
+
+func (c *TransformConfig) transformResponse(content []byte) ([]byte, error) {
+	var data any
+	if c.Response != nil {
+		obj, err := decodeObject(content)
+		if err != nil {
+			return nil, err
+		}
+		c.Response.apply(obj)
+		data = obj
+	} else {
+		data = json.RawMessage(content)
+		if len(content) == 0 {
+			data = nil
+		}
+	}
+
+	if c.Envelope != nil {
+		env := make(map[string]any, len(c.Envelope.Extra)+1)
+		for k, v := range c.Envelope.Extra {
+			env[k] = v
+		}
+		env[c.Envelope.Field] = data
+		data = env
+	}
+	return json.Marshal(data)
+}
+
+func (bt *BodyTransform) apply(obj map[string]any) {
+	if bt == nil {
+		return
+	}
+	for from, to := range bt.Rename {
+		if v, ok := getPath(obj, from); ok {
+			deletePath(obj, from)
+			setPath(obj, to, v)
+		}
+	}
+	for _, field := range bt.Drop {
+		deletePath(obj, field)
+	}
+}
+
+// decodeObject 空body视为空对象, 数字保持原样
+func decodeObject(b []byte) (map[string]any, error) {
+	obj := make(map[string]any)
+	if len(bytes.TrimSpace(b)) == 0 {
+		return obj, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func injectValue(v, typ string) (any, error) {
+	switch typ {
+	case InjectTypeInt:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case InjectTypeBool:
+		return strconv.ParseBool(v)
+	default:
+		return v, nil
+	}
+}
+
+func getPath(obj map[string]any, path string) (any, bool) {
+	keys := strings.Split(path, ".")
+	for i, k := range keys {
+		v, ok := obj[k]
+		if !ok {
+			return nil, false
+		}
+		if i == len(keys)-1 {
+			return v, true
+		}
+		if obj, ok = v.(map[string]any); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// setPath 中间对象不存在时创建
+func setPath(obj map[string]any, path string, v any) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := obj[k].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			obj[k] = next
+		}
+		obj = next
+	}
+	obj[keys[len(keys)-1]] = v
+}
+
+func deletePath(obj map[string]any, path string) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := obj[k].(map[string]any)
+		if !ok {
+			return
+		}
+		obj = next
+	}
+	delete(obj, keys[len(keys)-1])
+}
+
+// RewritePaths 将旧路径映射为新路径后交给next, 如/api/v1/user/get -> /rpc/user/UserService.Get
+// key以/结尾时按前缀替换
+func RewritePaths(paths map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to, ok := rewritePath(paths, r.URL.Path); ok {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = to
+			r2.URL.RawPath = ""
+			r2.RequestURI = r2.URL.RequestURI()
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rewritePath(paths map[string]string, path string) (string, bool) {
+	if to, ok := paths[path]; ok {
+		return to, true
+	}
+	// 最长前缀匹配
+	best := ""
+	for from := range paths {
+		if strings.HasSuffix(from, "/") && strings.HasPrefix(path, from) && len(from) > len(best) {
+			best = from
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+	return paths[best] + path[len(best):], true
+}
@@ -0,0 +1,106 @@
+package gate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vison888/go-vkit/grpcserver"
+)
+
+func TestTransform(t *testing.T) {
+	addr := startEchoServer(t, func(svr *grpcserver.GrpcServer) {
+		grpcserver.Handle(svr, "EchoService.Hello", func(ctx context.Context, req *echoReq, resp *echoResp) error {
+			resp.Msg = "hello " + req.Name
+			return nil
+		})
+	})
+	target := HttpTarget(func(service string) string { return addr })
+	cfg := &TransformConfig{
+		Request:  &BodyTransform{Rename: map[string]string{"user.username": "name"}, Drop: []string{"user"}},
+		Response: &BodyTransform{Rename: map[string]string{"msg": "message"}},
+		Envelope: &EnvelopeConfig{Field: "data", Extra: map[string]any{"code": 0}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewGrpcHandler(target, HttpWrapHandler(TransformWrapper(cfg)))
+	// 旧路径改写后再由GrpcHandler解析服务名
+	hdl := RewritePaths(map[string]string{"/api/v1/": "/rpc/echo/EchoService."}, http.HandlerFunc(h.Handle))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/Hello", strings.NewReader(`{"user":{"username":"bob","age":3}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	hdl.ServeHTTP(w, r)
+	if w.Code != 200 || w.Body.String() != `{"code":0,"data":{"message":"hello bob"}}` {
+		t.Fatalf("unexpected resp %d %s", w.Code, w.Body.String())
+	}
+
+	// 注入鉴权设置的值, 覆盖请求体中的同名字段
+	inject := &TransformConfig{Inject: []*InjectConfig{{Field: "name", Key: "x-user-id"}}}
+	auth := HttpAuthHandler(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Header.Get("Authorization") == "Bearer u1" {
+			r.Header.Set("x-user-id", "u1")
+		}
+		return nil
+	})
+	h = NewGrpcHandler(target, auth, HttpTransform(inject))
+	r = httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{"name":"spoof"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer u1")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "hello u1") {
+		t.Fatalf("unexpected inject resp %d %s", w.Code, w.Body.String())
+	}
+
+	// 客户端传入的注入头不生效
+	r = httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{"name":"spoof"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("x-user-id", "admin")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "admin") {
+		t.Fatalf("client header should not be injected %d %s", w.Code, w.Body.String())
+	}
+
+	// 缺少metadata时删除字段
+	r = httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{"name":"spoof"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "spoof") {
+		t.Fatalf("unexpected resp without metadata %d %s", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/rpc/echo/EchoService.Hello", strings.NewReader(`{bad`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.Handle(w, r)
+	if w.Code != 400 {
+		t.Fatalf("bad json should be rejected %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	paths := map[string]string{
+		"/v1/user/get": "/rpc/user/UserService.Get",
+		"/v1/":         "/rpc/legacy/",
+		"/v1/order/":   "/rpc/order/OrderService.",
+	}
+	cases := map[string]string{
+		"/v1/user/get":     "/rpc/user/UserService.Get",
+		"/v1/order/List":   "/rpc/order/OrderService.List",
+		"/v1/item/a":       "/rpc/legacy/item/a",
+		"/rpc/user/a/b":    "",
+		"/v1/user/get/abc": "/rpc/legacy/user/get/abc",
+	}
+	for from, want := range cases {
+		to, ok := rewritePath(paths, from)
+		if ok != (want != "") || to != want {
+			t.Fatalf("rewrite %s got %s want %s", from, to, want)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,12 +14,16 @@ import (
 	"github.com/vison888/go-vkit/logger"
 	"github.com/vison888/go-vkit/metadata"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/encoding"
 	gmetadata "google.golang.org/grpc/metadata"
 )
 
 var (
 	addr2conn sync.Map
+	// RegisterClient或首次GetConnClient设置的选项, 客户端删除后重建时沿用
+	addr2opts sync.Map
 	mutex     sync.Mutex
 
 	DefaultPoolMaxStreams = 20
@@ -32,12 +35,16 @@ var (
 	DefaultMaxSendMsgSize = 1024 * 1024 * 16
 	DefaultDialTimeout    = time.Second * 5
 	DefaultRequestTimeout = time.Second * 20
+
+	DefaultHealthCheckTimeout = time.Second
 )
 
 type customClient struct {
 	pool *pool
 	addr string
 	opts Options
+	// 未开启对冲时为nil
+	hedger *hedger
 }
 
 func init() {
@@ -45,22 +52,81 @@ func init() {
 	encoding.RegisterCodec(codec.WrapCodec{codec.ProtoCodec{}})
 }
 
+// NewClient 创建独立客户端, 不加入注册表, GetConnClient及网关转发不会使用
+// 需要共用时使用RegisterClient, 连接池统计通过ClientPoolStats取得
 func NewClient(addr string, opts ...Option) grpcx.Client {
-	pool := newPool(DefaultPoolSize, DefaultPoolTTL, DefaultPoolMaxIdle, DefaultPoolMaxStreams)
+	options := newOptions(opts...)
+	pool := newPool(options.PoolSize, options.PoolTTL, options.PoolMaxIdle, options.PoolMaxStreams, dialOptions(options)...)
 
 	ccc := &customClient{
 		pool: pool,
 		addr: addr,
-		opts: newOptions(opts...),
+		opts: options,
+	}
+	if ccc.opts.Hedge != nil {
+		ccc.hedger = newHedger(ccc.opts.Hedge)
+	}
+
+	if options.PoolWarmup > 0 {
+		pool.setWarmup(addr, options.PoolWarmup)
+		go pool.refill()
+	}
+	if options.PoolJanitor > 0 {
+		go pool.janitor(options.PoolJanitor)
+	}
+	if options.HealthCheckInterval > 0 {
+		go pool.healthLoop(options.HealthCheckInterval, options.HealthCheckTimeout)
 	}
 
 	return ccc
 }
 
+// RegisterClient 设置addr的客户端选项并替换已有客户端
+// 之后GetConnClient及网关转发均使用该客户端, 服务不可用被删除后按相同选项重建, 对冲策略等配置不会丢失
+func RegisterClient(addr string, opts ...Option) grpcx.Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	addr2opts.Store(addr, opts)
+	ccc := NewClient(addr, opts...)
+	if old, ok := addr2conn.Swap(addr, ccc); ok {
+		old.(*customClient).pool.stop()
+	}
+	return ccc
+}
+
 func DelConnClient(addr string) {
-	addr2conn.Delete(addr)
+	if iccc, ok := addr2conn.LoadAndDelete(addr); ok {
+		iccc.(*customClient).pool.stop()
+	}
 }
 
+// Stats 连接池统计
+func (ccc *customClient) Stats() PoolStats {
+	return ccc.pool.stats()
+}
+
+// GetPoolStats 返回注册表中addr对应客户端的连接池统计
+func GetPoolStats(addr string) (PoolStats, bool) {
+	iccc, ok := addr2conn.Load(addr)
+	if !ok {
+		return PoolStats{}, false
+	}
+	return iccc.(*customClient).Stats(), true
+}
+
+// ClientPoolStats 返回客户端的连接池统计, 可用于NewClient创建的客户端
+func ClientPoolStats(c grpcx.Client) (PoolStats, bool) {
+	ccc, ok := c.(*customClient)
+	if !ok {
+		return PoolStats{}, false
+	}
+	return ccc.Stats(), true
+}
+
+// GetConnClient 返回注册表中addr对应的客户端, 不存在时创建
+// 选项优先级: RegisterClient > 首次创建时传入的opts, 客户端已存在时opts被忽略
+// 首次传入的opts同样保存, 客户端被删除后按其重建; 网关转发不传选项, 需通过RegisterClient设置
 func GetConnClient(addr string, opts ...Option) grpcx.Client {
 	iccc, ok := addr2conn.Load(addr)
 	if ok {
@@ -74,6 +140,11 @@ func GetConnClient(addr string, opts ...Option) grpcx.Client {
 	if ok {
 		return iccc.(*customClient)
 	}
+	if v, ok := addr2opts.Load(addr); ok {
+		opts = v.([]Option)
+	} else {
+		addr2opts.Store(addr, opts)
+	}
 	ccc := NewClient(addr, opts...)
 
 	addr2conn.Store(addr, ccc)
@@ -98,25 +169,24 @@ func (ccc *customClient) Invoke(ctx context.Context, service, endpoint string, a
 		xContentType = v
 	}
 
+	// 超时优先级: ctx的deadline > metadata中的timeout(毫秒) > RequestTimeout
 	requestTimeout := ccc.opts.RequestTimeout
 	d, ok := ctx.Deadline()
 	if !ok {
-		if _, ok := header["timeout"]; ok {
-			timeout, err := strconv.Atoi(header["timeout"])
-			if err != nil {
-				logger.Errorf("timeout err:%s", err.Error())
+		if v, ok := header[grpcx.TimeoutKey]; ok {
+			if timeout, ok := grpcx.ParseTimeout(v); ok {
+				requestTimeout = timeout
 			} else {
-				requestTimeout = time.Second * time.Duration(timeout)
+				logger.Errorf("[grpcclient] invalid timeout:%s", v)
 			}
 		}
-		header["timeout"] = fmt.Sprintf("%d", requestTimeout)
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
 		defer cancel()
 	} else {
 		requestTimeout = time.Until(d)
-		header["timeout"] = fmt.Sprintf("%d", requestTimeout)
 	}
+	header[grpcx.TimeoutKey] = grpcx.FormatTimeout(requestTimeout)
 
 	header["x-content-type"] = xContentType
 	md := gmetadata.New(header)
@@ -127,62 +197,83 @@ func (ccc *customClient) Invoke(ctx context.Context, service, endpoint string, a
 		return neterrors.BadRequest("[grpcclient] codec not found")
 	}
 
-	grpcDialOptions := []grpc.DialOption{
-		grpc.WithTimeout(ccc.opts.DialTimeout),
-		grpc.WithInsecure(),
-		grpc.WithDefaultCallOptions(
-			grpc.MaxCallRecvMsgSize(ccc.opts.MaxRecvMsgSize),
-			grpc.MaxCallSendMsgSize(ccc.opts.MaxSendMsgSize),
-		),
+	grpcCallOptions := []grpc.CallOption{
+		grpc.ForceCodec(cf),
+		grpc.CallContentSubtype(cf.Name())}
+	grpcCallOptions = append(grpcCallOptions, opts...)
+
+	if ccc.hedger != nil && ccc.hedger.match(endpoint) {
+		return ccc.hedger.invoke(ctx, ccc, endpoint, method, args, reply, grpcCallOptions)
 	}
 
-	cc, err := ccc.pool.getConn(ccc.addr, grpcDialOptions...)
+	cc, err := ccc.pool.getConn(ccc.addr)
 	if err != nil {
 		return neterrors.BadRequest("[grpcclient] Error sending request: %v", err)
 	}
+	err = ccc.call(ctx, cc, method, args, reply, grpcCallOptions)
+	//服务不可用则直接删除client, 对冲请求由其他连接或地址重试, 不删除
+	if verr, ok := err.(*neterrors.NetError); ok && verr.Status == http.StatusServiceUnavailable {
+		logger.Infof("[grpcclient] remove client ccc.addr:%s", ccc.addr)
+		ccc.remove()
+	}
+	return err
+}
+
+// remove 从注册表删除当前客户端, 注册表中已是新客户端时不处理
+func (ccc *customClient) remove() {
+	if addr2conn.CompareAndDelete(ccc.addr, ccc) {
+		ccc.pool.stop()
+	}
+}
+
+// dialOptions 创建客户端时计算一次, 该客户端的所有连接共用
+func dialOptions(o Options) []grpc.DialOption {
+	creds := o.TransportCredentials
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: o.DialTimeout,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(o.MaxRecvMsgSize),
+			grpc.MaxCallSendMsgSize(o.MaxSendMsgSize),
+		),
+	}
+	if o.Keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(*o.Keepalive))
+	}
+	if o.Dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(o.Dialer))
+	}
+	return append(opts, o.DialOptions...)
+}
 
+// call 在已取得的连接上完成一次调用, 结束后归还连接
+func (ccc *customClient) call(ctx context.Context, cc *poolConn, method string, args any, reply any, opts []grpc.CallOption) error {
 	var grr error
 	defer func() {
-		//有error 连接将自动关闭
-		ccc.pool.release(ccc.addr, cc, grr)
-		//服务不可用则直接删除client
-		if grr != nil {
-			if verr, ok := grr.(*neterrors.NetError); ok {
-				//服务不可用 删除客户端
-				if verr.Status == http.StatusServiceUnavailable {
-					logger.Infof("[grpcclient] remove client ccc.addr:%s", ccc.addr)
-					DelConnClient(ccc.addr)
-				}
-			}
+		//调用方主动取消(如对冲落败)不视为连接错误
+		releaseErr := grr
+		if ctx.Err() == context.Canceled {
+			releaseErr = nil
 		}
+		//有error 连接将自动关闭
+		ccc.pool.release(cc.addr, cc, releaseErr)
 	}()
 
 	ch := make(chan error, 1)
 
 	go func() {
-		grpcCallOptions := []grpc.CallOption{
-			grpc.ForceCodec(cf),
-			grpc.CallContentSubtype(cf.Name())}
-		grpcCallOptions = append(grpcCallOptions, opts...)
-		err := cc.ClientConn.Invoke(ctx, method, args, reply, grpcCallOptions...)
+		err := cc.ClientConn.Invoke(ctx, method, args, reply, opts...)
 		if err == nil {
 			ch <- nil
 			return
 		}
-		errorStr := err.Error()
-		index := strings.Index(errorStr, "{\"")
-		if index != -1 {
-			errorRune := []rune(errorStr)
-			errorJson := errorRune[index:]
-			ch <- neterrors.Parse(string(errorJson))
-			return
-		}
-		index = strings.Index(errorStr, "code = Unavailable")
-		if index != -1 {
-			ch <- neterrors.ServiceUnavailable(err.Error())
-		} else {
-			ch <- neterrors.BadRequest("[grpcclient] req fail %v", err.Error())
-		}
+		ch <- ToNetError(err)
 	}()
 
 	select {
@@ -222,16 +313,7 @@ func (ccc *customClient) NewStream(ctx context.Context, desc *grpc.StreamDesc, s
 		return nil, neterrors.BadRequest("[grpcclient] codec not found")
 	}
 
-	grpcDialOptions := []grpc.DialOption{
-		grpc.WithTimeout(ccc.opts.DialTimeout),
-		grpc.WithInsecure(),
-		grpc.WithDefaultCallOptions(
-			grpc.MaxCallRecvMsgSize(ccc.opts.MaxRecvMsgSize),
-			grpc.MaxCallSendMsgSize(ccc.opts.MaxSendMsgSize),
-		),
-	}
-
-	cc, err := ccc.pool.getConn(ccc.addr, grpcDialOptions...)
+	cc, err := ccc.pool.getConn(ccc.addr)
 	if err != nil {
 		return nil, neterrors.BadRequest("[grpcclient] Error sending request: %v", err)
 	}
@@ -0,0 +1,106 @@
+package grpcclient
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/vison888/go-vkit/codec"
+	"github.com/vison888/go-vkit/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serveEcho 原样返回请求消息
+func serveEcho(t *testing.T, lis net.Listener) {
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error {
+		f := &codec.Frame{}
+		if err := stream.RecvMsg(f); err != nil {
+			return err
+		}
+		return stream.SendMsg(f)
+	}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+}
+
+// serveProxy 简单的http CONNECT代理
+func serveProxy(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestContextDialer(t *testing.T) {
+	bufLis := bufconn.Listen(1024 * 1024)
+	serveEcho(t, bufLis)
+
+	unixPath := filepath.Join(t.TempDir(), "echo.sock")
+	unixLis, err := net.Listen("unix", unixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveEcho(t, unixLis)
+
+	tcpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveEcho(t, tcpLis)
+
+	cases := map[string]struct {
+		addr string
+		opt  Option
+	}{
+		"bufconn": {"bufnet", ContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return bufLis.DialContext(ctx)
+		})},
+		"unix":  {"echo", UnixSocket(unixPath)},
+		"proxy": {tcpLis.Addr().String(), HttpProxy(serveProxy(t))},
+	}
+
+	ctx := metadata.NewContext(context.Background(), metadata.Metadata{"x-content-type": "application/json"})
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(c.addr, c.opt)
+			reply := &codec.Frame{}
+			if err := client.Invoke(ctx, "echo", "Echo.Get", &codec.Frame{Data: []byte(`{"a":1}`)}, reply); err != nil {
+				t.Fatal(err)
+			}
+			if string(reply.Data) != `{"a":1}` {
+				t.Fatalf("unexpected reply %s", reply.Data)
+			}
+		})
+	}
+}
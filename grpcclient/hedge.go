@@ -0,0 +1,304 @@
+package grpcclient
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	DefaultHedgePercentile  = 0.95
+	DefaultHedgeMinDelay    = time.Millisecond * 10
+	DefaultHedgeMaxAttempts = 2
+	DefaultHedgeMaxRatio    = 0.1
+)
+
+const (
+	// 延迟采样窗口
+	hedgeWindowSize = 256
+	// 样本数达到后才按分位计算延迟
+	hedgeMinSamples = 32
+	// 每新增多少样本重新计算分位
+	hedgeRecompute = 16
+	// 对冲配额上限, 限制突发
+	hedgeMaxTokens = 10
+)
+
+// HedgePolicy 对冲策略
+// 首次请求超过分位耗时仍未返回时, 向其他连接或地址再发一次, 取最先成功的结果并取消其余请求
+type HedgePolicy struct {
+	// 允许对冲的接口Struct.Method, 仅应配置只读接口
+	Endpoints []string
+	// 对冲延迟取近期耗时的该分位, 如0.95
+	Percentile float64
+	// 样本不足时的对冲延迟, 同时是延迟下限
+	MinDelay time.Duration
+	// 含首次请求在内的最大请求数
+	MaxAttempts int
+	// 对冲请求占请求总数的比例上限
+	MaxRatio float64
+	// 其他可用地址, 对冲请求依次发往这些地址, 为空时使用同地址的其他连接
+	Addrs []string
+}
+
+// HedgeStats 对冲统计
+type HedgeStats struct {
+	// 可对冲接口的请求数
+	Requests int64
+	// 发出的对冲请求数
+	Hedges int64
+	// 对冲请求先于首次请求成功的次数
+	Wins int64
+	// 因比例上限未发出的对冲数
+	Throttled int64
+}
+
+// HedgeRate 对冲请求数/请求数
+func (s HedgeStats) HedgeRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Hedges) / float64(s.Requests)
+}
+
+// GetHedgeStats 返回addr对应客户端的对冲统计, 未开启对冲时返回false
+func GetHedgeStats(addr string) (HedgeStats, bool) {
+	iccc, ok := addr2conn.Load(addr)
+	if !ok {
+		return HedgeStats{}, false
+	}
+	h := iccc.(*customClient).hedger
+	if h == nil {
+		return HedgeStats{}, false
+	}
+	return h.stats(), true
+}
+
+type hedger struct {
+	policy    HedgePolicy
+	endpoints map[string]bool
+	latencies sync.Map
+
+	tokenLock sync.Mutex
+	tokens    float64
+
+	requests  int64
+	hedges    int64
+	wins      int64
+	throttled int64
+}
+
+func newHedger(policy *HedgePolicy) *hedger {
+	h := &hedger{policy: *policy, endpoints: make(map[string]bool), tokens: hedgeMaxTokens}
+	if h.policy.Percentile <= 0 || h.policy.Percentile >= 1 {
+		h.policy.Percentile = DefaultHedgePercentile
+	}
+	if h.policy.MinDelay <= 0 {
+		h.policy.MinDelay = DefaultHedgeMinDelay
+	}
+	if h.policy.MaxAttempts < 2 {
+		h.policy.MaxAttempts = DefaultHedgeMaxAttempts
+	}
+	if h.policy.MaxRatio <= 0 {
+		h.policy.MaxRatio = DefaultHedgeMaxRatio
+	}
+	for _, e := range policy.Endpoints {
+		h.endpoints[e] = true
+	}
+	return h
+}
+
+func (h *hedger) match(endpoint string) bool {
+	return h.endpoints[endpoint]
+}
+
+func (h *hedger) stats() HedgeStats {
+	return HedgeStats{
+		Requests:  atomic.LoadInt64(&h.requests),
+		Hedges:    atomic.LoadInt64(&h.hedges),
+		Wins:      atomic.LoadInt64(&h.wins),
+		Throttled: atomic.LoadInt64(&h.throttled),
+	}
+}
+
+// deposit 每个请求积累MaxRatio个配额, 每次对冲消耗1个
+func (h *hedger) deposit() {
+	h.tokenLock.Lock()
+	h.tokens += h.policy.MaxRatio
+	if h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+	h.tokenLock.Unlock()
+}
+
+func (h *hedger) allow() bool {
+	h.tokenLock.Lock()
+	defer h.tokenLock.Unlock()
+	if h.tokens < 1 {
+		atomic.AddInt64(&h.throttled, 1)
+		return false
+	}
+	h.tokens--
+	atomic.AddInt64(&h.hedges, 1)
+	return true
+}
+
+func (h *hedger) window(endpoint string) *latencyWindow {
+	w, _ := h.latencies.LoadOrStore(endpoint, &latencyWindow{})
+	return w.(*latencyWindow)
+}
+
+func (h *hedger) addr(ccc *customClient, attempt int) string {
+	if attempt == 0 || len(h.policy.Addrs) == 0 {
+		return ccc.addr
+	}
+	return h.policy.Addrs[(attempt-1)%len(h.policy.Addrs)]
+}
+
+type hedgeResult struct {
+	attempt int
+	reply   any
+	cost    time.Duration
+	err     error
+}
+
+func (h *hedger) invoke(ctx context.Context, ccc *customClient, endpoint, method string, args any, reply any, opts []grpc.CallOption) error {
+	atomic.AddInt64(&h.requests, 1)
+	h.deposit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 每次请求使用独立的reply, 避免并发写
+	ch := make(chan hedgeResult, h.policy.MaxAttempts)
+	used := make(map[*poolConn]bool)
+	attempts := 0
+	start := func() error {
+		addr := h.addr(ccc, attempts)
+		var exclude map[*poolConn]bool
+		if addr == ccc.addr {
+			exclude = used
+		}
+		cc, err := ccc.pool.getConnExcept(addr, exclude)
+		if err != nil {
+			return neterrors.BadRequest("[grpcclient] Error sending request: %v", err)
+		}
+		used[cc] = true
+
+		attempt, r := attempts, newReply(reply)
+		attempts++
+		go func() {
+			begin := time.Now()
+			err := ccc.call(ctx, cc, method, args, r, opts)
+			ch <- hedgeResult{attempt: attempt, reply: r, cost: time.Since(begin), err: err}
+		}()
+		return nil
+	}
+
+	if err := start(); err != nil {
+		return err
+	}
+	pending := 1
+
+	w := h.window(endpoint)
+	timer := time.NewTimer(w.delay(h.policy))
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case res := <-ch:
+			pending--
+			if res.err == nil {
+				w.record(res.cost, h.policy.Percentile)
+				if res.attempt > 0 {
+					atomic.AddInt64(&h.wins, 1)
+				}
+				copyReply(reply, res.reply)
+				return nil
+			}
+			lastErr = res.err
+			// 仅服务不可用时立即对冲, 业务错误直接返回
+			verr, ok := res.err.(*neterrors.NetError)
+			if ok && verr.Status == http.StatusServiceUnavailable && attempts < h.policy.MaxAttempts && h.allow() {
+				if err := start(); err == nil {
+					pending++
+				}
+			}
+			if pending == 0 || !ok || verr.Status != http.StatusServiceUnavailable {
+				return lastErr
+			}
+		case <-timer.C:
+			if attempts < h.policy.MaxAttempts && h.allow() {
+				if err := start(); err == nil {
+					pending++
+				}
+			}
+			if attempts < h.policy.MaxAttempts {
+				timer.Reset(w.delay(h.policy))
+			}
+		}
+	}
+}
+
+func newReply(reply any) any {
+	return reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+}
+
+func copyReply(dst, src any) {
+	if m, ok := dst.(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, src.(proto.Message))
+		return
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
+
+// latencyWindow 接口近期成功请求的耗时
+type latencyWindow struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+	fresh   int
+	// 按分位计算的对冲延迟
+	current time.Duration
+}
+
+func (w *latencyWindow) record(cost time.Duration, percentile float64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.samples) < hedgeWindowSize {
+		w.samples = append(w.samples, cost)
+	} else {
+		w.samples[w.next] = cost
+		w.next = (w.next + 1) % hedgeWindowSize
+	}
+	w.fresh++
+	if len(w.samples) < hedgeMinSamples || w.fresh < hedgeRecompute {
+		return
+	}
+	w.fresh = 0
+
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	w.current = sorted[int(percentile*float64(len(sorted)-1))]
+}
+
+func (w *latencyWindow) delay(policy HedgePolicy) time.Duration {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.current < policy.MinDelay {
+		return policy.MinDelay
+	}
+	return w.current
+}
@@ -0,0 +1,103 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcserver"
+	"github.com/vison888/go-vkit/metadata"
+)
+
+type hedgeReq struct{}
+
+type hedgeResp struct {
+	N int32 `json:"n"`
+}
+
+func TestHedging(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	// 第一次请求很慢, 后续请求立即返回
+	var calls int32
+	svr := grpcserver.NewServer(grpcserver.Name("hedge"), grpcserver.GrpcAddr(addr))
+	grpcserver.Handle(svr, "Slow.Get", func(ctx context.Context, req *hedgeReq, resp *hedgeResp) error {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+		resp.N = n
+		return nil
+	})
+	grpcserver.Handle(svr, "Down.Get", func(ctx context.Context, req *hedgeReq, resp *hedgeResp) error {
+		return neterrors.ServiceUnavailable("down")
+	})
+	go svr.Run()
+	time.Sleep(time.Millisecond * 200)
+
+	c := RegisterClient(addr, Hedging(&HedgePolicy{
+		Endpoints: []string{"Slow.Get", "Down.Get"},
+		MinDelay:  time.Millisecond * 50,
+	}))
+	defer DelConnClient(addr)
+
+	ctx := metadata.NewContext(context.Background(), metadata.Metadata{"x-content-type": "application/json"})
+	begin := time.Now()
+	resp := &hedgeResp{}
+	if err := c.Invoke(ctx, "hedge", "Slow.Get", &hedgeReq{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(begin); cost > time.Millisecond*500 || resp.N != 2 {
+		t.Fatalf("hedge not applied cost:%s n:%d", cost, resp.N)
+	}
+
+	stats, ok := GetHedgeStats(addr)
+	if !ok || stats.Requests != 1 || stats.Hedges != 1 || stats.Wins != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if rate := stats.HedgeRate(); rate != 1 {
+		t.Fatalf("unexpected hedge rate %v", rate)
+	}
+
+	// 对冲请求返回503时不删除客户端, 统计保留
+	if err := c.Invoke(ctx, "hedge", "Down.Get", &hedgeReq{}, &hedgeResp{}); err == nil {
+		t.Fatal("down endpoint should fail")
+	}
+	if iccc, ok := addr2conn.Load(addr); !ok || iccc != c {
+		t.Fatal("hedged client should not be removed")
+	}
+
+	// 删除后按注册的选项重建, 对冲策略不丢失
+	DelConnClient(addr)
+	if GetConnClient(addr).(*customClient).hedger == nil {
+		t.Fatal("registered options should survive re-creation")
+	}
+}
+
+func TestHedgeThrottle(t *testing.T) {
+	h := newHedger(&HedgePolicy{Endpoints: []string{"A.B"}, MaxRatio: 0.5})
+	for i := 0; i < hedgeMaxTokens; i++ {
+		if !h.allow() {
+			t.Fatalf("initial tokens should allow hedge %d", i)
+		}
+	}
+	if h.allow() {
+		t.Fatal("hedge should be throttled")
+	}
+	h.deposit()
+	h.deposit()
+	if !h.allow() {
+		t.Fatal("two requests should earn one hedge")
+	}
+}
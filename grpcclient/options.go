@@ -1,12 +1,45 @@
 package grpcclient
 
-import "time"
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
 
 type Options struct {
 	MaxRecvMsgSize int
 	MaxSendMsgSize int
 	DialTimeout    time.Duration
 	RequestTimeout time.Duration
+	// 对冲策略, nil为不开启
+	Hedge *HedgePolicy
+	// 每个地址的最大连接数
+	PoolSize int
+	// 最大空闲连接数
+	PoolMaxIdle int
+	// 单连接最大并发请求数
+	PoolMaxStreams int
+	// 连接最长使用时间
+	PoolTTL time.Duration
+	// 后台清理过期及异常连接的间隔, 0为只在取连接时清理
+	PoolJanitor time.Duration
+	// 预热并保持的连接数
+	PoolWarmup int
+	// 健康检查间隔, 0为不开启
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// 客户端keepalive, nil为不开启
+	Keepalive *keepalive.ClientParameters
+	// 自定义建连方式, 如unix socket、bufconn、代理
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+	// 传输层凭证, nil为不加密
+	TransportCredentials credentials.TransportCredentials
+	// 追加的grpc.DialOption
+	DialOptions []grpc.DialOption
 }
 
 type Option func(o *Options)
@@ -17,6 +50,12 @@ func newOptions(opts ...Option) Options {
 		MaxSendMsgSize: DefaultMaxSendMsgSize,
 		DialTimeout:    DefaultDialTimeout,
 		RequestTimeout: DefaultRequestTimeout,
+		PoolSize:       DefaultPoolSize,
+		PoolMaxIdle:    DefaultPoolMaxIdle,
+		PoolMaxStreams: DefaultPoolMaxStreams,
+		PoolTTL:        DefaultPoolTTL,
+
+		HealthCheckTimeout: DefaultHealthCheckTimeout,
 	}
 	for _, o := range opts {
 		o(&opt)
@@ -47,3 +86,82 @@ func RequestTimeout(requestTimeout time.Duration) Option {
 		o.RequestTimeout = requestTimeout
 	}
 }
+
+func PoolSize(size int) Option {
+	return func(o *Options) {
+		o.PoolSize = size
+	}
+}
+
+func PoolMaxIdle(maxIdle int) Option {
+	return func(o *Options) {
+		o.PoolMaxIdle = maxIdle
+	}
+}
+
+func PoolMaxStreams(maxStreams int) Option {
+	return func(o *Options) {
+		o.PoolMaxStreams = maxStreams
+	}
+}
+
+func PoolTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.PoolTTL = ttl
+	}
+}
+
+// PoolJanitor 开启后台清理, 按interval关闭过期及异常的空闲连接
+func PoolJanitor(interval time.Duration) Option {
+	return func(o *Options) {
+		o.PoolJanitor = interval
+	}
+}
+
+// PoolWarmup 创建客户端时预先建立n个连接, 连接被清理后补足
+func PoolWarmup(n int) Option {
+	return func(o *Options) {
+		o.PoolWarmup = n
+	}
+}
+
+// HealthCheck 定期对空闲连接调用grpc.health.v1.Health/Check, 失败的连接主动关闭
+func HealthCheck(interval, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.HealthCheckInterval = interval
+		o.HealthCheckTimeout = timeout
+	}
+}
+
+// Keepalive 服务端需允许对应频率的ping, 否则会被GOAWAY
+func Keepalive(params keepalive.ClientParameters) Option {
+	return func(o *Options) {
+		o.Keepalive = &params
+	}
+}
+
+// ContextDialer 自定义建连, 测试时可传入bufconn.Listener.DialContext
+func ContextDialer(dialer func(ctx context.Context, addr string) (net.Conn, error)) Option {
+	return func(o *Options) {
+		o.Dialer = dialer
+	}
+}
+
+func TransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *Options) {
+		o.TransportCredentials = creds
+	}
+}
+
+func DialOptions(opts ...grpc.DialOption) Option {
+	return func(o *Options) {
+		o.DialOptions = append(o.DialOptions, opts...)
+	}
+}
+
+// Hedging 对只读接口开启对冲请求
+func Hedging(policy *HedgePolicy) Option {
+	return func(o *Options) {
+		o.Hedge = policy
+	}
+}
@@ -1,11 +1,19 @@
 package grpcclient
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/vison888/go-vkit/logger"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 type pool struct {
@@ -17,6 +25,41 @@ type pool struct {
 	maxIdle int
 	sync.Mutex
 	conns map[string]*streamsPool
+
+	dials        int64
+	dialFailures int64
+	evictions    int64
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	// stop后归还的连接直接关闭
+	stopped bool
+
+	// 创建客户端时确定, 所有连接共用
+	dialOpts []grpc.DialOption
+	// 预热地址及连接数, 清理后补足
+	warmAddr string
+	warmN    int
+}
+
+// PoolStats 连接池统计, 池满后临时创建的连接不计入
+type PoolStats struct {
+	// 池中连接数
+	Conns int
+	// 按连接状态统计, 如READY、IDLE、TRANSIENT_FAILURE
+	States map[string]int
+	// 并发请求已达上限的连接数
+	Busy int
+	// 没有进行中请求的连接数
+	Idle int
+	// 进行中的请求及流
+	Streams int
+	// 累计新建连接数
+	Dials int64
+	// 累计新建失败数
+	DialFailures int64
+	// 累计因过期、异常或空闲过多关闭的连接数
+	Evictions int64
 }
 
 type streamsPool struct {
@@ -48,7 +91,7 @@ type poolConn struct {
 	in   bool
 }
 
-func newPool(size int, ttl time.Duration, idle int, ms int) *pool {
+func newPool(size int, ttl time.Duration, idle int, ms int, opts ...grpc.DialOption) *pool {
 	if ms <= 0 {
 		ms = 1
 	}
@@ -61,10 +104,17 @@ func newPool(size int, ttl time.Duration, idle int, ms int) *pool {
 		maxStreams: ms,
 		maxIdle:    idle,
 		conns:      make(map[string]*streamsPool),
+		stopCh:     make(chan struct{}),
+		dialOpts:   opts,
 	}
 }
 
-func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error) {
+func (p *pool) getConn(addr string) (*poolConn, error) {
+	return p.getConnExcept(addr, nil)
+}
+
+// getConnExcept 跳过exclude中的连接, 没有可用连接时新建
+func (p *pool) getConnExcept(addr string, exclude map[*poolConn]bool) (*poolConn, error) {
 	now := time.Now().Unix()
 	p.Lock()
 	sp, ok := p.conns[addr]
@@ -76,6 +126,10 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 	//  otherwise we'll create a new conn
 	conn := sp.head.next
 	for conn != nil {
+		if exclude[conn] {
+			conn = conn.next
+			continue
+		}
 		//  check conn state
 		// https://github.com/grpc/grpc/blob/master/doc/connectivity-semantics-and-api.md
 		switch conn.GetState() {
@@ -87,6 +141,7 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 			if conn.streams == 0 {
 				removeConn(conn)
 				sp.idle--
+				atomic.AddInt64(&p.evictions, 1)
 			}
 			conn = next
 			continue
@@ -96,6 +151,7 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 				removeConn(conn)
 				conn.ClientConn.Close()
 				sp.idle--
+				atomic.AddInt64(&p.evictions, 1)
 			}
 			conn = next
 			continue
@@ -109,6 +165,7 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 				removeConn(conn)
 				conn.ClientConn.Close()
 				sp.idle--
+				atomic.AddInt64(&p.evictions, 1)
 			}
 			conn = next
 			continue
@@ -133,7 +190,7 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 	p.Unlock()
 
 	//  create new conn
-	cc, err := grpc.Dial(addr, opts...)
+	cc, err := p.dial(addr)
 	if err != nil {
 		return nil, err
 	}
@@ -152,6 +209,14 @@ func (p *pool) getConn(addr string, opts ...grpc.DialOption) (*poolConn, error)
 func (p *pool) release(addr string, conn *poolConn, err error) {
 	p.Lock()
 	p, sp, created := conn.pool, conn.sp, conn.created
+	if p.stopped {
+		if conn.in {
+			removeConn(conn)
+		}
+		p.Unlock()
+		conn.ClientConn.Close()
+		return
+	}
 	//  try to add conn
 	if !conn.in && sp.count < p.size {
 		addConnAfter(conn, sp.head)
@@ -175,6 +240,7 @@ func (p *pool) release(addr string, conn *poolConn, err error) {
 		now := time.Now().Unix()
 		if err != nil || sp.idle >= p.maxIdle || now-created > p.ttl {
 			removeConn(conn)
+			atomic.AddInt64(&p.evictions, 1)
 			p.Unlock()
 			conn.ClientConn.Close()
 			return
@@ -185,6 +251,220 @@ func (p *pool) release(addr string, conn *poolConn, err error) {
 	return
 }
 
+func (p *pool) stats() PoolStats {
+	st := PoolStats{
+		States:       make(map[string]int),
+		Dials:        atomic.LoadInt64(&p.dials),
+		DialFailures: atomic.LoadInt64(&p.dialFailures),
+		Evictions:    atomic.LoadInt64(&p.evictions),
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for _, sp := range p.conns {
+		for _, head := range []*poolConn{sp.head, sp.busy} {
+			for conn := head.next; conn != nil; conn = conn.next {
+				st.Conns++
+				st.States[conn.GetState().String()]++
+				st.Streams += conn.streams
+				if conn.streams >= p.maxStreams {
+					st.Busy++
+				}
+				if conn.streams == 0 {
+					st.Idle++
+				}
+			}
+		}
+	}
+	return st
+}
+
+// janitor 定期关闭过期及异常的空闲连接, 直到stop
+func (p *pool) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.sweep()
+		}
+	}
+}
+
+func (p *pool) sweep() {
+	now := time.Now().Unix()
+	closing := make([]*poolConn, 0)
+
+	p.Lock()
+	for _, sp := range p.conns {
+		conn := sp.head.next
+		for conn != nil {
+			next := conn.next
+			if conn.streams == 0 {
+				state := conn.GetState()
+				if now-conn.created > p.ttl || state == connectivity.Shutdown || state == connectivity.TransientFailure {
+					removeConn(conn)
+					sp.idle--
+					closing = append(closing, conn)
+				}
+			}
+			conn = next
+		}
+	}
+	p.Unlock()
+
+	for _, conn := range closing {
+		atomic.AddInt64(&p.evictions, 1)
+		conn.ClientConn.Close()
+	}
+	p.refill()
+}
+
+// dial 创建连接, 无scheme的地址按passthrough直连, 与原grpc.Dial行为一致
+func (p *pool) dial(addr string) (*grpc.ClientConn, error) {
+	atomic.AddInt64(&p.dials, 1)
+	target := addr
+	if !strings.Contains(addr, ":///") && !strings.HasPrefix(addr, "unix:") {
+		target = "passthrough:///" + addr
+	}
+	cc, err := grpc.NewClient(target, p.dialOpts...)
+	if err != nil {
+		atomic.AddInt64(&p.dialFailures, 1)
+	}
+	return cc, err
+}
+
+// setWarmup 保持addr至少n个连接
+func (p *pool) setWarmup(addr string, n int) {
+	p.Lock()
+	p.warmAddr, p.warmN = addr, n
+	p.Unlock()
+}
+
+// refill 提前建立连接, 补足到预热数量
+func (p *pool) refill() {
+	p.Lock()
+	addr, n := p.warmAddr, p.warmN
+	sp, ok := p.conns[addr]
+	if !ok {
+		sp = &streamsPool{head: &poolConn{}, busy: &poolConn{}, count: 0, idle: 0}
+		p.conns[addr] = sp
+	}
+	need := n - sp.count
+	if sp.count+need > p.size {
+		need = p.size - sp.count
+	}
+	p.Unlock()
+
+	for i := 0; i < need; i++ {
+		cc, err := p.dial(addr)
+		if err != nil {
+			logger.Errorf("[grpcclient] warmup dial addr:%s fail:%s", addr, err)
+			return
+		}
+		// 立即发起连接, 而不是等到第一个请求
+		cc.Connect()
+		conn := &poolConn{cc, nil, addr, p, sp, 0, time.Now().Unix(), nil, nil, false}
+
+		p.Lock()
+		if p.stopped || sp.count >= p.size {
+			p.Unlock()
+			cc.Close()
+			return
+		}
+		addConnAfter(conn, sp.head)
+		sp.idle++
+		p.Unlock()
+	}
+}
+
+// healthCheck 对空闲连接发起健康检查, 失败的连接关闭后补足
+// 服务端未实现健康检查时, 能收到响应即视为健康
+func (p *pool) healthCheck(timeout time.Duration) {
+	checking := make([]*poolConn, 0)
+	p.Lock()
+	for _, sp := range p.conns {
+		for conn := sp.head.next; conn != nil; conn = conn.next {
+			if conn.streams == 0 {
+				checking = append(checking, conn)
+			}
+		}
+	}
+	p.Unlock()
+
+	for _, conn := range checking {
+		// 逐个借出, 检查期间不会被清理, 其余空闲连接仍可处理请求
+		// 期间已被借出或关闭的连接跳过
+		p.Lock()
+		if p.stopped || !conn.in || conn.streams != 0 {
+			p.Unlock()
+			continue
+		}
+		conn.sp.idle--
+		conn.streams++
+		p.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp, err := healthpb.NewHealthClient(conn.ClientConn).Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+		if status.Code(err) == codes.Unimplemented {
+			err = nil
+		} else if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+			err = fmt.Errorf("health status %s", resp.Status)
+		}
+		if err != nil {
+			logger.Infof("[grpcclient] health check addr:%s fail:%s", conn.addr, err)
+		}
+		p.release(conn.addr, conn, err)
+	}
+	p.refill()
+}
+
+// healthLoop 按interval健康检查, 直到stop
+func (p *pool) healthLoop(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.healthCheck(timeout)
+		}
+	}
+}
+
+// stop 停止后台任务并关闭空闲连接, 使用中的连接在归还时关闭
+func (p *pool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+
+		closing := make([]*poolConn, 0)
+		p.Lock()
+		p.stopped = true
+		for _, sp := range p.conns {
+			for _, head := range []*poolConn{sp.head, sp.busy} {
+				for conn := head.next; conn != nil; {
+					next := conn.next
+					if conn.streams == 0 {
+						removeConn(conn)
+						closing = append(closing, conn)
+					}
+					conn = next
+				}
+			}
+			sp.idle = 0
+		}
+		p.Unlock()
+
+		for _, conn := range closing {
+			conn.ClientConn.Close()
+		}
+	})
+}
+
 func (conn *poolConn) Close() {
 	conn.pool.release(conn.addr, conn, conn.err)
 }
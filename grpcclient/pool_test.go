@@ -0,0 +1,209 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/grpcserver"
+	"github.com/vison888/go-vkit/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestPoolStats(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	svr := grpcserver.NewServer(grpcserver.Name("pool"), grpcserver.GrpcAddr(addr))
+	grpcserver.Handle(svr, "Echo.Get", func(ctx context.Context, req *hedgeReq, resp *hedgeResp) error {
+		resp.N = 1
+		return nil
+	})
+	go svr.Run()
+	time.Sleep(time.Millisecond * 200)
+
+	c := GetConnClient(addr, PoolTTL(time.Second), PoolJanitor(time.Millisecond*100))
+	defer DelConnClient(addr)
+
+	ctx := metadata.NewContext(context.Background(), metadata.Metadata{"x-content-type": "application/json"})
+	if err := c.Invoke(ctx, "pool", "Echo.Get", &hedgeReq{}, &hedgeResp{}); err != nil {
+		t.Fatal(err)
+	}
+
+	st, ok := GetPoolStats(addr)
+	if !ok || st.Conns != 1 || st.Idle != 1 || st.Streams != 0 || st.Dials != 1 || st.States["READY"] != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	// 连接过期后由janitor关闭
+	time.Sleep(time.Millisecond * 2500)
+	st, _ = GetPoolStats(addr)
+	if st.Conns != 0 || st.Evictions != 1 {
+		t.Fatalf("expired conn should be evicted %+v", st)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	GetConnClient(addr, PoolWarmup(2), HealthCheck(time.Millisecond*100, time.Millisecond*500))
+	defer DelConnClient(addr)
+
+	// 健康检查期间连接被借出, 轮询等待状态
+	waitStats := func(cond func(st PoolStats) bool) PoolStats {
+		var st PoolStats
+		for i := 0; i < 40; i++ {
+			st, _ = GetPoolStats(addr)
+			if cond(st) {
+				break
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+		return st
+	}
+
+	st := waitStats(func(st PoolStats) bool { return st.Conns == 2 && st.States["READY"] == 2 })
+	if st.Conns != 2 || st.Dials != 2 || st.Evictions != 0 {
+		t.Fatalf("warmup conns not ready %+v", st)
+	}
+
+	// 不健康的连接被关闭后补足
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	st = waitStats(func(st PoolStats) bool { return st.Evictions >= 2 && st.Conns == 2 })
+	if st.Evictions < 2 || st.Conns != 2 || st.Dials < 4 {
+		t.Fatalf("unhealthy conns should be replaced %+v", st)
+	}
+}
+
+type blockingHealth struct {
+	healthpb.UnimplementedHealthServer
+	calls   chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.calls <- struct{}{}
+	<-h.release
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestPoolHealthCheckBorrow(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	srv := grpc.NewServer()
+	hs := &blockingHealth{calls: make(chan struct{}), release: make(chan struct{})}
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	c := NewClient(addr, PoolWarmup(2)).(*customClient)
+	defer c.pool.stop()
+	for i := 0; i < 40 && c.Stats().Idle != 2; i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.pool.healthCheck(time.Second)
+		close(done)
+	}()
+
+	// 每次只借出一个连接检查
+	for i := 0; i < 2; i++ {
+		<-hs.calls
+		if st := c.Stats(); st.Idle != 1 || st.Streams != 1 {
+			t.Fatalf("only one conn should be borrowed %+v", st)
+		}
+		hs.release <- struct{}{}
+	}
+	<-done
+	if st := c.Stats(); st.Idle != 2 || st.Evictions != 0 {
+		t.Fatalf("healthy conns should be kept %+v", st)
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	c := GetConnClient(addr, PoolWarmup(2)).(*customClient)
+	time.Sleep(time.Millisecond * 200)
+	conns := make([]*grpc.ClientConn, 0)
+	c.pool.Lock()
+	for conn := c.pool.conns[addr].head.next; conn != nil; conn = conn.next {
+		conns = append(conns, conn.ClientConn)
+	}
+	c.pool.Unlock()
+	if len(conns) != 2 {
+		t.Fatalf("warmup conns %d", len(conns))
+	}
+
+	// 删除客户端后关闭池中连接
+	DelConnClient(addr)
+	if st := c.Stats(); st.Conns != 0 {
+		t.Fatalf("pool should be empty %+v", st)
+	}
+	for _, cc := range conns {
+		if cc.GetState() != connectivity.Shutdown {
+			t.Fatalf("conn should be closed, state:%s", cc.GetState())
+		}
+	}
+}
+
+func TestClientRegistry(t *testing.T) {
+	addr := "registry.test:10000"
+	defer DelConnClient(addr)
+
+	// 首次传入的选项生效并保存, 删除后按其重建
+	GetConnClient(addr, RequestTimeout(time.Second))
+	GetConnClient(addr, RequestTimeout(time.Minute))
+	DelConnClient(addr)
+	if c := GetConnClient(addr).(*customClient); c.opts.RequestTimeout != time.Second {
+		t.Fatalf("first options should be kept %s", c.opts.RequestTimeout)
+	}
+
+	// RegisterClient替换已有客户端, 优先于GetConnClient的选项
+	RegisterClient(addr, RequestTimeout(time.Hour), PoolSize(3))
+	if c := GetConnClient(addr, RequestTimeout(time.Second)).(*customClient); c.opts.RequestTimeout != time.Hour {
+		t.Fatalf("registered options should win %s", c.opts.RequestTimeout)
+	}
+	if _, ok := GetPoolStats(addr); !ok {
+		t.Fatal("registered client should have stats")
+	}
+
+	// NewClient不加入注册表
+	c := NewClient(addr, PoolSize(5))
+	if r := GetConnClient(addr); r == c {
+		t.Fatal("NewClient should not be registered")
+	}
+	if _, ok := ClientPoolStats(c); !ok {
+		t.Fatal("NewClient should have stats")
+	}
+	c.(*customClient).pool.stop()
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"sync"
 
+	"github.com/vison888/go-vkit/grpcx"
 	"google.golang.org/grpc"
 )
 
@@ -68,3 +69,29 @@ func (g *grpcStream) Close() error {
 	g.close(g.err)
 	return g.ClientStream.CloseSend()
 }
+
+// TypedStream 类型化的客户端流, 由vkitgen生成的客户端返回
+type TypedStream[Req, Resp any] struct {
+	grpcx.ClientStream
+}
+
+func NewTypedStream[Req, Resp any](stream grpcx.ClientStream) *TypedStream[Req, Resp] {
+	return &TypedStream[Req, Resp]{ClientStream: stream}
+}
+
+func (s *TypedStream[Req, Resp]) Send(req *Req) error {
+	return s.ClientStream.Send(req)
+}
+
+// CloseSend 客户端流发送完毕后调用
+func (s *TypedStream[Req, Resp]) CloseSend() error {
+	return grpcx.CloseSend(s.ClientStream)
+}
+
+func (s *TypedStream[Req, Resp]) Recv() (*Resp, error) {
+	resp := new(Resp)
+	if err := s.ClientStream.Recv(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/vison888/go-vkit/codec"
 	"github.com/vison888/go-vkit/errorsx/neterrors"
 	"github.com/vison888/go-vkit/grpcx"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -35,6 +37,21 @@ func InvokeByGate(ctx context.Context, addrName string, service, endpoint string
 	return nil, neterrors.BadRequest(err.Error()).(*neterrors.NetError)
 }
 
+// RawByGate 透传已编码的消息, 编码由ctx中的x-content-type决定
+func RawByGate(ctx context.Context, addrName string, service, endpoint string, body []byte) ([]byte, *neterrors.NetError) {
+	ccc, ok := GetClient(addrName)
+	if !ok {
+		ccc = GetConnClient(addrName)
+	}
+
+	reply := &codec.Frame{}
+	err := ccc.Invoke(ctx, service, endpoint, &codec.Frame{Data: body}, reply)
+	if err == nil {
+		return reply.Data, nil
+	}
+	return nil, ToNetError(err)
+}
+
 func StreamByGate(ctx context.Context, addrName string, service, endpoint string) (grpcx.ClientStream, *neterrors.NetError) {
 	//get conn from addrName
 	ccc, ok := GetClient(addrName)
@@ -60,6 +77,26 @@ func StreamByGate(ctx context.Context, addrName string, service, endpoint string
 	return nil, neterrors.BadRequest(err.Error()).(*neterrors.NetError)
 }
 
+// ToNetError 将grpc调用返回的error转换为NetError
+func ToNetError(err error) *neterrors.NetError {
+	if err == nil {
+		return nil
+	}
+	if verr, ok := err.(*neterrors.NetError); ok {
+		return verr
+	}
+	if st, ok := status.FromError(err); ok {
+		return neterrors.FromStatus(st)
+	}
+
+	errorStr := err.Error()
+	index := strings.Index(errorStr, "{\"")
+	if index != -1 {
+		return neterrors.Parse(errorStr[index:])
+	}
+	return neterrors.BadRequest("[grpcclient] req fail %v", errorStr).(*neterrors.NetError)
+}
+
 func GetClient(addrName string) (grpcx.Client, bool) {
 	addr, ok := serverName2Addr[addrName]
 	if !ok {
@@ -2,6 +2,7 @@ package grpcserver
 
 import (
 	"context"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -9,10 +10,15 @@ import (
 type HandlerFunc func(ctx context.Context, req *GrpcRequest, rsp any) error
 type HandlerWrapper func(HandlerFunc) HandlerFunc
 
+// StreamWrapper 包装流式接口的grpc.ServerStream, 可对每条消息做日志、统计、校验或限流
+type StreamWrapper func(ctx context.Context, req *GrpcRequest, stream grpc.ServerStream) grpc.ServerStream
+
 var (
 	DefaultGrpcAddr       = "0.0.0.0:10000"
 	DefaultMaxRecvMsgSize = 1024 * 1024 * 16
 	DefaultMaxSendMsgSize = 1024 * 1024 * 16
+	// 客户端keepalive ping的最小间隔
+	DefaultKeepaliveMinTime = time.Second * 10
 )
 
 type GrpcOptions struct {
@@ -21,6 +27,7 @@ type GrpcOptions struct {
 	MaxRecvMsgSize int
 	MaxSendMsgSize int
 	HdlrWrappers   []HandlerWrapper
+	StreamWrappers []StreamWrapper
 	Gopts          []grpc.ServerOption
 }
 
@@ -32,6 +39,7 @@ func newGrpcOptions(opts ...GrpcOption) GrpcOptions {
 		MaxRecvMsgSize: DefaultMaxRecvMsgSize,
 		MaxSendMsgSize: DefaultMaxSendMsgSize,
 		HdlrWrappers:   make([]HandlerWrapper, 0),
+		StreamWrappers: make([]StreamWrapper, 0),
 		Gopts:          make([]grpc.ServerOption, 0),
 		Name:           "",
 	}
@@ -47,6 +55,13 @@ func GrpcWrapHandler(w HandlerWrapper) GrpcOption {
 	}
 }
 
+// GrpcWrapStream 添加流包装, 先添加的在最外层
+func GrpcWrapStream(w StreamWrapper) GrpcOption {
+	return func(o *GrpcOptions) {
+		o.StreamWrappers = append(o.StreamWrappers, w)
+	}
+}
+
 func GrpcAddr(addr string) GrpcOption {
 	return func(o *GrpcOptions) {
 		o.GrpcAddr = addr
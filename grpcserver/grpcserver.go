@@ -3,13 +3,12 @@ package grpcserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
 	"runtime/debug"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/vison888/go-vkit/codec"
 	"github.com/vison888/go-vkit/errorsx"
@@ -17,8 +16,12 @@ import (
 	"github.com/vison888/go-vkit/grpcx"
 	"github.com/vison888/go-vkit/logger"
 	meta "github.com/vison888/go-vkit/metadata"
+	"github.com/vison888/go-vkit/validatex"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
@@ -29,10 +32,11 @@ const (
 )
 
 type handlerInfo struct {
-	handler      reflect.Value
-	method       reflect.Method
-	reqType      reflect.Type
-	respType     reflect.Type
+	grpcx.Invoker
+	// 泛型注册的流式接口
+	streamCall   StreamHandlerFunc
+	url          string
+	method       string
 	clientStream bool
 	serverStream bool
 }
@@ -59,11 +63,17 @@ func NewServer(opts ...GrpcOption) *GrpcServer {
 		grpc.MaxRecvMsgSize(g.opts.MaxRecvMsgSize),
 		grpc.MaxSendMsgSize(g.opts.MaxSendMsgSize),
 		grpc.UnknownServiceHandler(g.handler),
+		// 允许客户端keepalive及空闲时的ping, 可通过Gopts覆盖
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             DefaultKeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
 	}
 
 	gopts = append(gopts, g.opts.Gopts...)
 	g.srv = grpc.NewServer(gopts...)
 	reflection.Register(g.srv)
+	healthpb.RegisterHealthServer(g.srv, health.NewServer())
 	return g
 }
 
@@ -102,7 +112,7 @@ func (g *GrpcServer) handler(srv any, stream grpc.ServerStream) (err error) {
 		md[k] = strings.Join(v, ", ")
 	}
 
-	to := md["timeout"]
+	to := md[grpcx.TimeoutKey]
 	xct := DefaultContentType
 
 	if ctype, ok := md["x-content-type"]; ok {
@@ -117,7 +127,7 @@ func (g *GrpcServer) handler(srv any, stream grpc.ServerStream) (err error) {
 		ct = ctype
 	}
 	md["content-type"] = ct
-	delete(md, "timeout")
+	delete(md, grpcx.TimeoutKey)
 	delete(md, "x-content-type")
 
 	// create new context
@@ -130,12 +140,10 @@ func (g *GrpcServer) handler(srv any, stream grpc.ServerStream) (err error) {
 	}
 
 	// set the timeout if we have it
-	if len(to) > 0 {
-		if n, err := strconv.ParseUint(to, 10, 64); err == nil {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(ctx, time.Duration(n))
-			defer cancel()
-		}
+	if timeout, ok := grpcx.ParseTimeout(to); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
 	h, b := g.handlers[methodName]
@@ -148,6 +156,10 @@ func (g *GrpcServer) handler(srv any, stream grpc.ServerStream) (err error) {
 		return neterrors.NotFound(errorStr)
 	}
 
+	if h.streamCall != nil {
+		return g.processTypedStream(stream, h, ct, methodName, ctx)
+	}
+
 	if h.clientStream || h.serverStream {
 		return g.processStream(stream, h, ct, xct, methodName, ctx)
 	}
@@ -156,62 +168,40 @@ func (g *GrpcServer) handler(srv any, stream grpc.ServerStream) (err error) {
 }
 
 func (g *GrpcServer) processStream(stream grpc.ServerStream, h *handlerInfo, ct string, xct string, methodName string, ctx context.Context) error {
-	replyv := reflect.New(h.respType.Elem())
-	setStreamFunc, b := h.respType.MethodByName("SetStream")
-	if b {
-		setStreamFunc.Func.Call([]reflect.Value{reflect.ValueOf(replyv.Interface()), reflect.ValueOf(stream)})
-	}
-
 	r := &GrpcRequest{
 		service:     g.opts.Name,
 		contentType: ct,
 		method:      methodName,
 		stream:      false,
 	}
+	stream = g.wrapStream(ctx, r, stream)
+
+	replyv := h.NewResp()
+	if setStreamFunc := reflect.ValueOf(replyv).MethodByName("SetStream"); setStreamFunc.IsValid() {
+		setStreamFunc.Call([]reflect.Value{reflect.ValueOf(stream)})
+	}
 
-	var argv reflect.Value
-	if h.reqType != nil {
-		argv = reflect.New(h.reqType.Elem())
-		r.payload = argv.Interface()
+	var argv any
+	if h.NewReq != nil {
+		argv = h.NewReq()
+		r.payload = argv
 	}
 
 	fn := func(ctx context.Context, req *GrpcRequest, rsp any) (err error) {
-		var in []reflect.Value
-		var out []reflect.Value
-		if h.reqType != nil {
+		if argv != nil {
 			//read first data
-			if err := stream.RecvMsg(argv.Interface()); err != nil {
+			if err := stream.RecvMsg(argv); err != nil {
 				return err
 			}
-			in = make([]reflect.Value, 4)
-			in[0] = h.handler
-			in[1] = reflect.ValueOf(ctx)
-			in[2] = argv
-			in[3] = replyv
-			out = h.method.Func.Call(in)
-		} else {
-			in = make([]reflect.Value, 3)
-			in[0] = h.handler
-			in[1] = reflect.ValueOf(ctx)
-			in[2] = replyv
-			out = h.method.Func.Call(in)
 		}
-		if rerr := out[0].Interface(); rerr != nil {
-			if verr, ok := rerr.(error); ok {
-				return verr
-			} else {
-				return fmt.Errorf("stream error %v", rerr)
-			}
-		}
-
-		return nil
+		return h.Call(ctx, argv, replyv)
 	}
 
 	for i := len(g.opts.HdlrWrappers); i > 0; i-- {
 		fn = g.opts.HdlrWrappers[i-1](fn)
 	}
 
-	if appErr := fn(ctx, r, replyv.Interface()); appErr != nil {
+	if appErr := fn(ctx, r, replyv); appErr != nil {
 		switch verr := appErr.(type) {
 		case *neterrors.NetError:
 			return appErr
@@ -224,11 +214,11 @@ func (g *GrpcServer) processStream(stream grpc.ServerStream, h *handlerInfo, ct
 }
 
 func (g *GrpcServer) processRequest(stream grpc.ServerStream, h *handlerInfo, ct string, xct string, methodName string, ctx context.Context) error {
-	argv := reflect.New(h.reqType.Elem())
-	replyv := reflect.New(h.respType.Elem())
+	argv := h.NewReq()
+	replyv := h.NewResp()
 
 	if cd := codec.DefaultGRPCCodecs[xct]; cd.Name() != "json" {
-		if err := stream.RecvMsg(argv.Interface()); err != nil {
+		if err := stream.RecvMsg(argv); err != nil {
 			errorStr := fmt.Sprintf("[Grpcserver] RecvMsg error: %s", err.Error())
 			logger.Errorf(errorStr)
 			return neterrors.BadRequest(errorStr)
@@ -241,7 +231,7 @@ func (g *GrpcServer) processRequest(stream grpc.ServerStream, h *handlerInfo, ct
 			return neterrors.BadRequest(errorStr)
 		}
 
-		if err := cd.Unmarshal(raw, argv.Interface()); err != nil {
+		if err := cd.Unmarshal(raw, argv); err != nil {
 			errorStr := fmt.Sprintf("[Grpcserver] Unmarshal error: %s", err.Error())
 			logger.Errorf(errorStr)
 			return neterrors.BadRequest(errorStr)
@@ -253,40 +243,32 @@ func (g *GrpcServer) processRequest(stream grpc.ServerStream, h *handlerInfo, ct
 		contentType: ct,
 		method:      methodName,
 		stream:      false,
-		payload:     argv.Interface(),
+		payload:     argv,
 	}
 
 	fn := func(ctx context.Context, req *GrpcRequest, rsp any) (err error) {
 		// validate
-		validateFunc, b := h.reqType.MethodByName("Validate")
-		if b {
-			out := validateFunc.Func.Call([]reflect.Value{reflect.ValueOf(argv.Interface())})
-			errValue := out[0]
-			if errValue.Interface() != nil {
-				err := errValue.Interface().(error)
-				errorStr := fmt.Sprintf("[Grpcserver]  param error: %s", err.Error())
-				logger.Errorf(errorStr)
-				return neterrors.BusinessError(-1, errorStr)
-			}
+		if err := validatex.Check(argv); err != nil {
+			logger.Errorf("[Grpcserver]  param error: %s", err.Error())
+			return err
 		}
 
-		in := make([]reflect.Value, 4)
-		in[0] = h.handler
-		in[1] = reflect.ValueOf(ctx)
-		in[2] = argv
-		in[3] = replyv
-		out := h.method.Func.Call(in)
-		if rerr := out[0].Interface(); rerr != nil {
+		if rerr := h.Call(ctx, argv, replyv); rerr != nil {
 			//处理业务异常
-			if verr, ok := rerr.(*errorsx.Errno); ok {
+			var verr *errorsx.Errno
+			var nerr *neterrors.NetError
+			if errors.As(rerr, &verr) {
 				if verr.Code != 0 {
-					errorStr := fmt.Sprintf("[Grpcserver] call error: %s", verr.Error())
-					logger.Errorf(errorStr)
-					return neterrors.BusinessError(verr.Code, verr.Msg)
+					logger.Errorf("[Grpcserver] call error: %s %s", rerr.Error(), neterrors.StackOf(rerr))
+					return verr.ToNetError()
 				}
+			} else if errors.As(rerr, &nerr) {
+				//NetError原样返回, 保留详情
+				logger.Errorf("[Grpcserver] call error: %s %s", rerr.Error(), neterrors.StackOf(rerr))
+				return nerr
 			} else {
 				//其他异常统一包装
-				errorStr := fmt.Sprintf("[Grpcserver] call error: %s", rerr.(error).Error())
+				errorStr := fmt.Sprintf("[Grpcserver] call error: %s", rerr.Error())
 				logger.Errorf(errorStr)
 				return neterrors.BusinessError(-2, errorStr)
 			}
@@ -299,12 +281,12 @@ func (g *GrpcServer) processRequest(stream grpc.ServerStream, h *handlerInfo, ct
 	}
 
 	// execute the handler
-	if appErr := fn(ctx, r, replyv.Interface()); appErr != nil {
+	if appErr := fn(ctx, r, replyv); appErr != nil {
 		switch verr := appErr.(type) {
 		case *neterrors.NetError:
 			// 检查回调
-			field1 := argv.Elem().FieldByName("Code")
-			field2 := argv.Elem().FieldByName("Msg")
+			field1 := reflect.ValueOf(argv).Elem().FieldByName("Code")
+			field2 := reflect.ValueOf(argv).Elem().FieldByName("Msg")
 			if field1.CanSet() && field2.CanSet() {
 				field1.Set(reflect.ValueOf(verr.Code))
 				field2.Set(reflect.ValueOf(verr.Msg))
@@ -316,7 +298,7 @@ func (g *GrpcServer) processRequest(stream grpc.ServerStream, h *handlerInfo, ct
 		}
 	}
 
-	if err := stream.SendMsg(replyv.Interface()); err != nil {
+	if err := stream.SendMsg(replyv); err != nil {
 		errorStr := fmt.Sprintf("[Grpcserver] send error: %s", err.Error())
 		logger.Errorf(errorStr)
 		return neterrors.BusinessError(-2, errorStr)
@@ -362,35 +344,25 @@ func (g *GrpcServer) RegisterWithUrl(i any, apiEndpointMap map[string]*grpcx.Api
 			serverStream = desc.ServerStream
 			reqMethod = desc.Method
 		}
-		var reqType reflect.Type
-		var respType reflect.Type
-		if m.Type.NumIn() == 3 {
-			respType = m.Type.In(2)
-		} else if m.Type.NumIn() == 4 {
-			reqType = m.Type.In(2)
-			respType = m.Type.In(3)
-		} else {
-			panic("in param numbre error methodName:=" + methodName)
-		}
+		handler := &handlerInfo{Invoker: grpcx.ReflectInvoker(o, m, methodName)}
+		handler.clientStream = clientStream
+		handler.serverStream = serverStream
 
-		handler := &handlerInfo{
-			handler:      o,
-			method:       m,
-			reqType:      reqType,
-			respType:     respType,
-			clientStream: clientStream,
-			serverStream: serverStream,
-		}
-
-		g.handlers[reqUrl] = handler
-		if reqMethod != "" {
-			g.handlers[reqMethod] = handler
-		}
-		logger.Infof("[GrpcServer] Register reqUrl:%s reqMethod:%s", reqUrl, reqMethod)
+		g.register(reqUrl, reqMethod, handler)
 	}
 	return nil
 }
 
+func (g *GrpcServer) register(reqUrl string, reqMethod string, handler *handlerInfo) {
+	handler.url = reqUrl
+	handler.method = reqMethod
+	g.handlers[reqUrl] = handler
+	if reqMethod != "" {
+		g.handlers[reqMethod] = handler
+	}
+	logger.Infof("[GrpcServer] Register reqUrl:%s reqMethod:%s", reqUrl, reqMethod)
+}
+
 func (g *GrpcServer) Register(i any) (err error) {
 	return g.RegisterWithUrl(i, nil)
 }
@@ -404,7 +376,17 @@ func (g *GrpcServer) Run() {
 		return
 	}
 
-	if err := g.srv.Serve(lis); err != nil {
+	if err := g.Serve(lis); err != nil {
 		logger.Errorf("failed to serve: %v", err)
 	}
 }
+
+// Serve 在已监听的lis上提供服务, 阻塞至Stop或出错
+func (g *GrpcServer) Serve(lis net.Listener) error {
+	return g.srv.Serve(lis)
+}
+
+// Stop 关闭监听及所有连接, 进行中的请求被中断
+func (g *GrpcServer) Stop() {
+	g.srv.Stop()
+}
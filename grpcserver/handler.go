@@ -0,0 +1,20 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/vison888/go-vkit/grpcx"
+)
+
+// Handle 注册类型化handler, 签名在编译期校验, 调用时不经过反射
+// url为Struct.Method或ApiEndpoint的Url
+func Handle[Req, Resp any](g *GrpcServer, url string, fn func(ctx context.Context, req *Req, resp *Resp) error) {
+	g.register(url, "", &handlerInfo{Invoker: grpcx.TypedInvoker(fn)})
+}
+
+// Endpoints 返回已注册的接口, 用于生成接口文档
+func (g *GrpcServer) Endpoints() []*grpcx.EndpointInfo {
+	return grpcx.Endpoints(g.handlers, func(hi *handlerInfo) *grpcx.EndpointInfo {
+		return hi.EndpointInfo(g.opts.Name, hi.method, hi.url, hi.clientStream, hi.serverStream)
+	})
+}
@@ -0,0 +1,28 @@
+package grpcserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	svr := NewServer(Name("auth"))
+	Handle(svr, "/rpc/sso/AuthService.RefleshUrl", (&AuthService{}).RefleshUrl)
+	svr.Register(&AuthService{})
+
+	for _, url := range []string{"/rpc/sso/AuthService.RefleshUrl", "AuthService.RefleshUrl"} {
+		h, ok := svr.handlers[url]
+		if !ok {
+			t.Fatalf("handler %s not found", url)
+		}
+		req := h.NewReq().(*RefleshUrlReq)
+		req.Id = 1
+		resp := h.NewResp().(*RefleshUrlResp)
+		if err := h.Call(context.Background(), req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != 100001 {
+			t.Fatalf("unexpected resp id %d", resp.Id)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"github.com/vison888/go-vkit/errorsx"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcx"
+	"github.com/vison888/go-vkit/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// StreamHandlerFunc 流式调用的处理函数
+type StreamHandlerFunc func(ctx context.Context, req *GrpcRequest, stream grpc.ServerStream) error
+
+// StreamInterceptor 单个流式接口的拦截器, 包裹整个流的生命周期
+type StreamInterceptor func(StreamHandlerFunc) StreamHandlerFunc
+
+// ServerStream 类型化的服务端流, 用于客户端流及服务端流
+type ServerStream[Req, Resp any] struct {
+	ctx    context.Context
+	stream grpc.ServerStream
+}
+
+func newServerStream[Req, Resp any](ctx context.Context, stream grpc.ServerStream) *ServerStream[Req, Resp] {
+	return &ServerStream[Req, Resp]{
+		ctx:    ctx,
+		stream: stream,
+	}
+}
+
+func (s *ServerStream[Req, Resp]) Context() context.Context {
+	return s.ctx
+}
+
+// Recv 读取下一条消息, 客户端半关闭时返回io.EOF, ctx结束时返回ctx.Err()
+func (s *ServerStream[Req, Resp]) Recv() (*Req, error) {
+	// ctx结束后上一次RecvMsg可能仍未返回, 不能并发读
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// ctx超时后processTypedStream结束调用, 流随之关闭, RecvMsg返回
+	req := new(Req)
+	if err := s.stream.RecvMsg(req); err != nil {
+		if cerr := s.ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+func (s *ServerStream[Req, Resp]) Send(resp *Resp) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.stream.SendMsg(resp)
+}
+
+// BidiStream 类型化的双向流, Send可在多个goroutine中并发调用
+type BidiStream[Req, Resp any] struct {
+	*ServerStream[Req, Resp]
+	sendLock sync.Mutex
+}
+
+func (s *BidiStream[Req, Resp]) Send(resp *Resp) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.ServerStream.Send(resp)
+}
+
+// HandleServerStream 注册服务端流接口, 读取一个请求后由fn持续发送
+func HandleServerStream[Req, Resp any](g *GrpcServer, url string, fn func(ctx context.Context, req *Req, stream *ServerStream[Req, Resp]) error, interceptors ...StreamInterceptor) {
+	call := func(ctx context.Context, r *GrpcRequest, stream grpc.ServerStream) error {
+		req := new(Req)
+		if err := stream.RecvMsg(req); err != nil {
+			if err == io.EOF {
+				return neterrors.BadRequest("[Grpcserver] request is empty")
+			}
+			return err
+		}
+		r.payload = req
+		return fn(ctx, req, newServerStream[Req, Resp](ctx, stream))
+	}
+	g.registerStream(url, streamHandlerInfo[Req, Resp](false, true), call, interceptors)
+}
+
+// HandleClientStream 注册客户端流接口, fn读取到io.EOF后返回唯一的响应
+func HandleClientStream[Req, Resp any](g *GrpcServer, url string, fn func(ctx context.Context, stream *ServerStream[Req, Resp]) (*Resp, error), interceptors ...StreamInterceptor) {
+	call := func(ctx context.Context, r *GrpcRequest, stream grpc.ServerStream) error {
+		resp, err := fn(ctx, newServerStream[Req, Resp](ctx, stream))
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg(resp)
+	}
+	g.registerStream(url, streamHandlerInfo[Req, Resp](true, false), call, interceptors)
+}
+
+// HandleBidiStream 注册双向流接口
+func HandleBidiStream[Req, Resp any](g *GrpcServer, url string, fn func(ctx context.Context, stream *BidiStream[Req, Resp]) error, interceptors ...StreamInterceptor) {
+	call := func(ctx context.Context, r *GrpcRequest, stream grpc.ServerStream) error {
+		return fn(ctx, &BidiStream[Req, Resp]{ServerStream: newServerStream[Req, Resp](ctx, stream)})
+	}
+	g.registerStream(url, streamHandlerInfo[Req, Resp](true, true), call, interceptors)
+}
+
+func (g *GrpcServer) registerStream(url string, h *handlerInfo, call StreamHandlerFunc, interceptors []StreamInterceptor) {
+	for i := len(interceptors); i > 0; i-- {
+		call = interceptors[i-1](call)
+	}
+	h.streamCall = call
+	g.register(url, "", h)
+}
+
+func streamHandlerInfo[Req, Resp any](clientStream bool, serverStream bool) *handlerInfo {
+	return &handlerInfo{
+		Invoker: grpcx.Invoker{
+			ReqType:  reflect.TypeOf((*Req)(nil)),
+			RespType: reflect.TypeOf((*Resp)(nil)),
+		},
+		clientStream: clientStream,
+		serverStream: serverStream,
+	}
+}
+
+// processTypedStream 处理泛型注册的流式接口
+func (g *GrpcServer) processTypedStream(stream grpc.ServerStream, h *handlerInfo, ct string, methodName string, ctx context.Context) error {
+	r := &GrpcRequest{
+		service:     g.opts.Name,
+		contentType: ct,
+		method:      methodName,
+		stream:      true,
+	}
+	stream = g.wrapStream(ctx, r, stream)
+
+	fn := func(ctx context.Context, req *GrpcRequest, rsp any) error {
+		return h.streamCall(ctx, req, stream)
+	}
+
+	for i := len(g.opts.HdlrWrappers); i > 0; i-- {
+		fn = g.opts.HdlrWrappers[i-1](fn)
+	}
+
+	if err := g.callStream(ctx, stream, fn, r); err != nil {
+		var verr *errorsx.Errno
+		if errors.As(err, &verr) {
+			if verr.Code == 0 {
+				return nil
+			}
+			return verr.ToNetError()
+		}
+		return err
+	}
+	return nil
+}
+
+// callStream ctx带有比流更短的超时时, 超时即结束调用, 流的context随之取消
+// 阻塞在RecvMsg的handler由此返回, 无需为每条消息启动goroutine
+func (g *GrpcServer) callStream(ctx context.Context, stream grpc.ServerStream, fn HandlerFunc, r *GrpcRequest) error {
+	if ctx.Done() == stream.Context().Done() {
+		return fn(ctx, r, nil)
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		defer func() {
+			if re := recover(); re != nil {
+				logger.Errorf("[Grpcserver] stream panic recovered:%v", re)
+				logger.Error(string(debug.Stack()))
+				ch <- neterrors.BadRequest("[Grpcserver] panic recovered:%v", re)
+			}
+		}()
+		ch <- fn(ctx, r, nil)
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (g *GrpcServer) wrapStream(ctx context.Context, r *GrpcRequest, stream grpc.ServerStream) grpc.ServerStream {
+	for i := len(g.opts.StreamWrappers); i > 0; i-- {
+		stream = g.opts.StreamWrappers[i-1](ctx, r, stream)
+	}
+	return stream
+}
@@ -0,0 +1,190 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type chunkReq struct {
+	Data string `json:"data"`
+}
+
+type chunkResp struct {
+	Total int `json:"total"`
+}
+
+func upload(ctx context.Context, stream *ServerStream[chunkReq, chunkResp]) (*chunkResp, error) {
+	resp := &chunkResp{}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return resp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Total += len(req.Data)
+	}
+}
+
+func dialBufconn(t *testing.T, svr *GrpcServer) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+	go svr.srv.Serve(lis)
+	t.Cleanup(svr.srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func callUpload(t *testing.T, conn *grpc.ClientConn, chunks []string) *chunkResp {
+	desc := &grpc.StreamDesc{StreamName: "Upload", ClientStreams: true}
+	stream, err := conn.NewStream(context.Background(), desc, "/file.FileService/Upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range chunks {
+		if err := stream.SendMsg(&chunkReq{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	resp := &chunkResp{}
+	if err := stream.RecvMsg(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandleClientStream(t *testing.T) {
+	svr := NewServer(Name("file"))
+	HandleClientStream(svr, "FileService.Upload", upload)
+
+	conn := dialBufconn(t, svr)
+	defer conn.Close()
+
+	if resp := callUpload(t, conn, []string{"ab", "cde", "f"}); resp.Total != 6 {
+		t.Fatalf("unexpected total %d", resp.Total)
+	}
+}
+
+func TestStreamTimeout(t *testing.T) {
+	returned := make(chan error, 1)
+	svr := NewServer(Name("file"))
+	HandleClientStream(svr, "FileService.Upload", func(ctx context.Context, stream *ServerStream[chunkReq, chunkResp]) (*chunkResp, error) {
+		_, err := stream.Recv()
+		returned <- err
+		return nil, err
+	})
+
+	conn := dialBufconn(t, svr)
+	defer conn.Close()
+
+	// 网关透传的超时比流更短, 客户端不发送消息时handler也应在超时后返回
+	ctx := metadata.AppendToOutgoingContext(context.Background(), grpcx.TimeoutKey, "100")
+	desc := &grpc.StreamDesc{StreamName: "Upload", ClientStreams: true}
+	stream, err := conn.NewStream(ctx, desc, "/file.FileService/Upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := stream.RecvMsg(&chunkResp{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("unexpected err %v", err)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("timeout not enforced, cost:%s", cost)
+	}
+	select {
+	case err := <-returned:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("recv should return ctx err, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler still blocked in Recv")
+	}
+}
+
+type countStream struct {
+	grpc.ServerStream
+	recv *int
+}
+
+func (s *countStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		*s.recv++
+	}
+	return err
+}
+
+func TestGrpcWrapStream(t *testing.T) {
+	recv := 0
+	svr := NewServer(Name("file"), GrpcWrapStream(func(ctx context.Context, req *GrpcRequest, stream grpc.ServerStream) grpc.ServerStream {
+		return &countStream{ServerStream: stream, recv: &recv}
+	}))
+	HandleClientStream(svr, "FileService.Upload", upload)
+
+	conn := dialBufconn(t, svr)
+	defer conn.Close()
+
+	callUpload(t, conn, []string{"a", "b", "c", "d"})
+	if recv != 4 {
+		t.Fatalf("unexpected recv count %d", recv)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	svr := NewServer(Name("file"))
+	conn := dialBufconn(t, svr)
+	defer conn.Close()
+
+	err := conn.Invoke(context.Background(), "/file.FileService/Missing", &chunkReq{}, &chunkResp{})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("unexpected code %v err:%v", code, err)
+	}
+	if netErr := neterrors.FromError(err); netErr.Status != 404 || netErr.Code != -1 {
+		t.Fatalf("unexpected net error %v", netErr)
+	}
+}
+
+type createReq struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func TestValidateViolations(t *testing.T) {
+	svr := NewServer(Name("file"))
+	Handle(svr, "FileService.Create", func(ctx context.Context, req *createReq, resp *chunkResp) error {
+		return nil
+	})
+	conn := dialBufconn(t, svr)
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-content-type", "application/json")
+	err := conn.Invoke(ctx, "/file.FileService/Create", &createReq{}, &chunkResp{})
+	netErr := neterrors.FromError(err)
+	if netErr == nil || len(netErr.Violations) != 1 || netErr.Violations[0].Field != "name" {
+		t.Fatalf("unexpected net error %v", netErr)
+	}
+}
@@ -0,0 +1,113 @@
+package grpcx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Invoker 接口的请求、响应类型及调用方式, 由grpcserver及gate的handler注册共用
+type Invoker struct {
+	// 无请求参数时为nil
+	NewReq   func() any
+	NewResp  func() any
+	Call     func(ctx context.Context, req any, resp any) error
+	ReqType  reflect.Type
+	RespType reflect.Type
+}
+
+// TypedInvoker 类型化handler, 调用时不经过反射
+func TypedInvoker[Req, Resp any](fn func(ctx context.Context, req *Req, resp *Resp) error) Invoker {
+	return Invoker{
+		ReqType:  reflect.TypeOf((*Req)(nil)),
+		RespType: reflect.TypeOf((*Resp)(nil)),
+		NewReq: func() any {
+			return new(Req)
+		},
+		NewResp: func() any {
+			return new(Resp)
+		},
+		Call: func(ctx context.Context, req any, resp any) error {
+			return fn(ctx, req.(*Req), resp.(*Resp))
+		},
+	}
+}
+
+// ReflectInvoker 兼容反射注册, 方法签名为(ctx, req, resp) error或(ctx, resp) error
+func ReflectInvoker(o reflect.Value, m reflect.Method, methodName string) Invoker {
+	var reqType reflect.Type
+	var respType reflect.Type
+	if m.Type.NumIn() == 3 {
+		respType = m.Type.In(2)
+	} else if m.Type.NumIn() == 4 {
+		reqType = m.Type.In(2)
+		respType = m.Type.In(3)
+	} else {
+		panic("in param numbre error methodName:=" + methodName)
+	}
+
+	inv := Invoker{
+		ReqType:  reqType,
+		RespType: respType,
+		NewResp: func() any {
+			return reflect.New(respType.Elem()).Interface()
+		},
+		Call: func(ctx context.Context, req any, resp any) error {
+			in := []reflect.Value{o, reflect.ValueOf(ctx)}
+			if reqType != nil {
+				in = append(in, reflect.ValueOf(req))
+			}
+			in = append(in, reflect.ValueOf(resp))
+			out := m.Func.Call(in)
+			if rerr := out[0].Interface(); rerr != nil {
+				if verr, ok := rerr.(error); ok {
+					return verr
+				}
+				return fmt.Errorf("call error %v", rerr)
+			}
+			return nil
+		},
+	}
+	if reqType != nil {
+		inv.NewReq = func() any {
+			return reflect.New(reqType.Elem()).Interface()
+		}
+	}
+	return inv
+}
+
+// EndpointInfo 生成接口描述, 请求及响应类型取结构体类型
+func (inv *Invoker) EndpointInfo(service, method, url string, clientStream, serverStream bool) *EndpointInfo {
+	info := &EndpointInfo{
+		Service:      service,
+		Method:       method,
+		Url:          url,
+		ClientStream: clientStream,
+		ServerStream: serverStream,
+	}
+	if inv.ReqType != nil {
+		info.ReqType = inv.ReqType.Elem()
+	}
+	if inv.RespType != nil {
+		info.RespType = inv.RespType.Elem()
+	}
+	return info
+}
+
+// Endpoints 按url排序返回已注册的接口, 同一handler以多个url注册时只返回一次
+func Endpoints[H comparable](handlers map[string]H, info func(h H) *EndpointInfo) []*EndpointInfo {
+	seen := make(map[H]bool)
+	list := make([]*EndpointInfo, 0, len(handlers))
+	for _, h := range handlers {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		list = append(list, info(h))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Url < list[j].Url
+	})
+	return list
+}
@@ -2,6 +2,8 @@ package grpcx
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 
 	"google.golang.org/grpc"
 )
@@ -13,6 +15,19 @@ type ApiEndpoint struct {
 	ServerStream bool
 }
 
+// EndpointInfo 已注册接口的描述, 用于生成接口文档
+type EndpointInfo struct {
+	// 服务名, 网关路径为/rpc/{Service}/{Url}
+	Service      string
+	Method       string
+	Url          string
+	ClientStream bool
+	ServerStream bool
+	// 请求结构体类型, 无请求参数时为nil
+	ReqType  reflect.Type
+	RespType reflect.Type
+}
+
 type FileInfo struct {
 	Filename string
 	Size     int64
@@ -32,6 +47,21 @@ type ClientStream interface {
 	Close() error
 }
 
+// HalfCloser 可单独关闭发送方向的流, grpcclient创建的流均实现
+// 未加入ClientStream, 避免已有的ClientStream实现及mock需要修改
+type HalfCloser interface {
+	// CloseSend closes the send direction of the stream
+	CloseSend() error
+}
+
+// CloseSend 关闭流的发送方向, 流未实现HalfCloser时返回错误
+func CloseSend(s ClientStream) error {
+	if hc, ok := s.(HalfCloser); ok {
+		return hc.CloseSend()
+	}
+	return fmt.Errorf("stream %T does not support CloseSend", s)
+}
+
 type Client interface {
 	Invoke(ctx context.Context, serive, endpoint string, args any, reply any, opts ...grpc.CallOption) error
 	NewStream(ctx context.Context, desc *grpc.StreamDesc, serive, endpoint string, opts ...grpc.CallOption) (ClientStream, error)
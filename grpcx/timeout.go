@@ -0,0 +1,26 @@
+package grpcx
+
+import (
+	"strconv"
+	"time"
+)
+
+// TimeoutKey 透传剩余超时的metadata键, 单位毫秒
+const TimeoutKey = "timeout"
+
+func FormatTimeout(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// ParseTimeout 解析毫秒超时, 非法或非正数返回false
+func ParseTimeout(v string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
@@ -0,0 +1,188 @@
+// Package openapi 根据已注册的接口生成OpenAPI 3文档
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"github.com/vison888/go-vkit/grpcx"
+)
+
+const (
+	Version3 = "3.0.3"
+
+	errorSchemaName    = "NetError"
+	securitySchemeName = "ApiKeyAuth"
+	jsonContentType    = "application/json"
+)
+
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []*ServerInfo         `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components *Components           `json:"components,omitempty"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type ServerInfo struct {
+	Url string `json:"url"`
+}
+
+type PathItem struct {
+	Post *Operation `json:"post,omitempty"`
+}
+
+type Operation struct {
+	OperationId string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// 流式接口的方向
+	Stream *StreamInfo `json:"x-stream,omitempty"`
+}
+
+type StreamInfo struct {
+	ClientStream bool `json:"clientStream"`
+	ServerStream bool `json:"serverStream"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	In   string `json:"in,omitempty"`
+}
+
+// New 生成文档, endpoints来自GrpcServer.Endpoints或NativeHandler.Endpoints
+// Url为Struct.Method时路径为BasePath/{服务名}/Struct.Method, 与网关路由一致
+func New(endpoints []*grpcx.EndpointInfo, opts ...Option) *Document {
+	o := newOptions(opts...)
+
+	doc := &Document{
+		OpenAPI: Version3,
+		Info: Info{
+			Title:       o.Title,
+			Description: o.Description,
+			Version:     o.Version,
+		},
+		Paths: make(map[string]*PathItem),
+		Components: &Components{
+			Schemas: make(map[string]*Schema),
+		},
+	}
+	for _, url := range o.Servers {
+		doc.Servers = append(doc.Servers, &ServerInfo{Url: url})
+	}
+	if o.AuthHeader != "" {
+		doc.Components.SecuritySchemes = map[string]*SecurityScheme{
+			securitySchemeName: {Type: "apiKey", Name: o.AuthHeader, In: "header"},
+		}
+		doc.Security = []map[string][]string{{securitySchemeName: {}}}
+	}
+
+	b := &schemaBuilder{schemas: doc.Components.Schemas}
+	b.schemas[errorSchemaName] = errorSchema(b, o)
+
+	for _, ep := range endpoints {
+		path := ep.Url
+		if !strings.HasPrefix(path, "/") {
+			service := ep.Service
+			if service == "" {
+				service = o.Service
+			}
+			path = strings.TrimSuffix(o.BasePath, "/") + "/" + service + "/" + path
+		}
+		method := ep.Method
+		if method == "" {
+			method = path[strings.LastIndex(path, "/")+1:]
+		}
+
+		op := &Operation{
+			OperationId: method,
+			Summary:     method,
+			Responses: map[string]*Response{
+				"default": {
+					Description: "错误, 业务错误时http状态码为200",
+					Content:     jsonContent(&Schema{Ref: refPrefix + errorSchemaName}),
+				},
+			},
+		}
+		if index := strings.Index(method, "."); index != -1 {
+			op.Tags = []string{method[:index]}
+		}
+		if ep.ClientStream || ep.ServerStream {
+			op.Description = "websocket流式接口"
+			op.Stream = &StreamInfo{ClientStream: ep.ClientStream, ServerStream: ep.ServerStream}
+		}
+		if ep.ReqType != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  jsonContent(b.schemaOf(ep.ReqType)),
+			}
+		}
+		if ep.RespType != nil {
+			op.Responses["200"] = &Response{
+				Description: "成功",
+				Content:     jsonContent(b.schemaOf(ep.RespType)),
+			}
+		}
+		doc.Paths[path] = &PathItem{Post: op}
+	}
+	return doc
+}
+
+func jsonContent(s *Schema) map[string]*MediaType {
+	return map[string]*MediaType{
+		jsonContentType: {Schema: s},
+	}
+}
+
+func errorSchema(b *schemaBuilder, o Options) *Schema {
+	s := b.structSchema(reflect.TypeOf(neterrors.NetError{}))
+	if len(o.Errnos) == 0 {
+		return s
+	}
+
+	errnos := append(o.Errnos[:0:0], o.Errnos...)
+	sort.Slice(errnos, func(i, j int) bool {
+		return errnos[i].Code < errnos[j].Code
+	})
+	lines := make([]string, 0, len(errnos))
+	for _, e := range errnos {
+		lines = append(lines, fmt.Sprintf("%d: %s", e.Code, e.Msg))
+	}
+	if code, ok := s.Properties["code"]; ok {
+		code.Description = "业务错误码\n" + strings.Join(lines, "\n")
+	}
+	return s
+}
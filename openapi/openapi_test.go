@@ -0,0 +1,76 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vison888/go-vkit/errorsx"
+	"github.com/vison888/go-vkit/grpcx"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Base struct {
+	Id int64 `json:"id"`
+}
+
+type LoginReq struct {
+	Base
+	Name     string            `json:"name"`
+	Tags     []string          `json:"tags,omitempty"`
+	Ignore   string            `json:"-"`
+	Children []*LoginReq       `json:"children"`
+	Extra    map[string]string `json:"extra"`
+}
+
+func TestNew(t *testing.T) {
+	doc := New([]*grpcx.EndpointInfo{{
+		Method:   "AuthService.Login",
+		Url:      "/rpc/sso/AuthService.Login",
+		ReqType:  reflect.TypeOf(LoginReq{}),
+		RespType: reflect.TypeOf(wrapperspb.StringValue{}),
+	}, {
+		Service:      "sso",
+		Url:          "AuthService.Watch",
+		ServerStream: true,
+		RespType:     reflect.TypeOf(Base{}),
+	}}, AuthHeader("AuthToken"), Errnos(errorsx.PARAM_ERR))
+
+	op := doc.Paths["/rpc/sso/AuthService.Login"].Post
+	if op == nil || op.Tags[0] != "AuthService" {
+		t.Fatalf("login operation not found %+v", doc.Paths)
+	}
+	if ref := op.RequestBody.Content[jsonContentType].Schema.Ref; ref != refPrefix+"openapi.LoginReq" {
+		t.Fatalf("unexpected request ref %s", ref)
+	}
+
+	req := doc.Components.Schemas["openapi.LoginReq"]
+	for _, name := range []string{"id", "name", "tags", "children", "extra"} {
+		if _, ok := req.Properties[name]; !ok {
+			t.Fatalf("property %s not found", name)
+		}
+	}
+	if _, ok := req.Properties["Ignore"]; ok {
+		t.Fatalf("ignored property found")
+	}
+	if req.Properties["children"].Items.Ref != refPrefix+"openapi.LoginReq" {
+		t.Fatalf("unexpected recursive ref")
+	}
+
+	pb := doc.Components.Schemas["google.protobuf.StringValue"]
+	if pb == nil || pb.Properties["value"].Type != "string" {
+		t.Fatalf("unexpected proto schema %+v", pb)
+	}
+
+	watch := doc.Paths["/rpc/sso/AuthService.Watch"].Post
+	if watch == nil || watch.Stream == nil || !watch.Stream.ServerStream || watch.RequestBody != nil {
+		t.Fatalf("unexpected watch operation %+v", watch)
+	}
+
+	if doc.Components.SecuritySchemes[securitySchemeName].Name != "AuthToken" {
+		t.Fatalf("security scheme not found")
+	}
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package openapi
+
+import "github.com/vison888/go-vkit/errorsx"
+
+var (
+	DefaultTitle    = "go-vkit"
+	DefaultVersion  = "1.0.0"
+	DefaultBasePath = "/rpc"
+)
+
+type Options struct {
+	Title       string
+	Version     string
+	Description string
+	// Url不是完整路径时拼接的前缀, 路径为BasePath/{服务名}/{Url}
+	BasePath string
+	// 接口未带服务名时使用的服务名
+	Service string
+	Servers []string
+	// 鉴权使用的header, 为空时不生成鉴权要求
+	AuthHeader string
+	// 文档中列出的业务错误码
+	Errnos []*errorsx.Errno
+}
+
+type Option func(o *Options)
+
+func newOptions(opts ...Option) Options {
+	opt := Options{
+		Title:    DefaultTitle,
+		Version:  DefaultVersion,
+		BasePath: DefaultBasePath,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+func Title(title string) Option {
+	return func(o *Options) {
+		o.Title = title
+	}
+}
+
+func Version(version string) Option {
+	return func(o *Options) {
+		o.Version = version
+	}
+}
+
+func Description(description string) Option {
+	return func(o *Options) {
+		o.Description = description
+	}
+}
+
+func BasePath(basePath string) Option {
+	return func(o *Options) {
+		o.BasePath = basePath
+	}
+}
+
+func Service(name string) Option {
+	return func(o *Options) {
+		o.Service = name
+	}
+}
+
+func Server(url string) Option {
+	return func(o *Options) {
+		o.Servers = append(o.Servers, url)
+	}
+}
+
+func AuthHeader(name string) Option {
+	return func(o *Options) {
+		o.AuthHeader = name
+	}
+}
+
+func Errnos(list ...*errorsx.Errno) Option {
+	return func(o *Options) {
+		o.Errnos = append(o.Errnos, list...)
+	}
+}
@@ -41,6 +41,11 @@ func NewClient(addr, password string, db int) (*RedisClient, error) {
 	return &RedisClient{c: rdb}, nil
 }
 
+// IsNil key不存在
+func IsNil(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
+
 func GetFullKey(key *RedisKey, sub string) string {
 	if sub == "" {
 		return key.Code
@@ -68,6 +73,20 @@ func (c *RedisClient) SetJson(key *RedisKey, sub string, value any) error {
 	return c.c.Set(context.Background(), fullKey, string(bytes), key.Expire).Err()
 }
 
+// SetNX key不存在时写入, 返回是否写入成功
+func (c *RedisClient) SetNX(key *RedisKey, sub string, value any) (bool, error) {
+	fullKey := GetFullKey(key, sub)
+	return c.c.SetNX(context.Background(), fullKey, value, key.Expire).Result()
+}
+
+func (c *RedisClient) SetNXJson(key *RedisKey, sub string, value any) (bool, error) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return c.SetNX(key, sub, string(bytes))
+}
+
 func (c *RedisClient) GetString(key *RedisKey, sub string) (string, error) {
 	fullKey := GetFullKey(key, sub)
 	return c.c.Get(context.Background(), fullKey).Result()
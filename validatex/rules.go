@@ -0,0 +1,188 @@
+package validatex
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type rule struct {
+	name string
+	arg  string
+	num  float64
+	n    int
+	re   *regexp.Regexp
+	enum map[string]bool
+}
+
+// parseRules 解析规则, 如"required,min=1,max=10,maxlen=32,enum=a|b,regex=^[a-z]+$"
+// regex可能包含逗号, 需放在最后
+func parseRules(tag string, t reflect.Type) ([]*rule, error) {
+	rules := make([]*rule, 0)
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		r := &rule{name: name, arg: arg}
+		if err := r.compile(t); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", part, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (r *rule) compile(t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch r.name {
+	case "required":
+		return nil
+	case "min", "max":
+		if !isNumber(t.Kind()) {
+			return fmt.Errorf("type %s is not number", t)
+		}
+		num, err := strconv.ParseFloat(r.arg, 64)
+		r.num = num
+		return err
+	case "len", "minlen", "maxlen":
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		default:
+			return fmt.Errorf("type %s has no length", t)
+		}
+		n, err := strconv.Atoi(r.arg)
+		r.n = n
+		return err
+	case "regex":
+		if t.Kind() != reflect.String {
+			return fmt.Errorf("type %s is not string", t)
+		}
+		re, err := regexp.Compile(r.arg)
+		r.re = re
+		return err
+	case "enum":
+		if t.Kind() != reflect.String && !isInt(t.Kind()) {
+			return fmt.Errorf("type %s can not be enum", t)
+		}
+		// protobuf枚举未列出取值时, 校验是否为定义的枚举值
+		if r.arg == "" {
+			if !t.Implements(reflect.TypeOf((*protoreflect.Enum)(nil)).Elem()) {
+				return fmt.Errorf("enum values required")
+			}
+			return nil
+		}
+		r.enum = make(map[string]bool)
+		for _, v := range strings.Split(r.arg, "|") {
+			r.enum[strings.TrimSpace(v)] = true
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown rule")
+	}
+}
+
+// key 规则的稳定标识, 作为校验失败的描述, 客户端据此翻译, 如"required"、"maxlen=32"、"enum=a|b"
+// regex不带表达式, 避免泄露校验细节
+func (r *rule) key() string {
+	if r.arg == "" || r.name == "regex" {
+		return r.name
+	}
+	return r.name + "=" + r.arg
+}
+
+// check 校验失败时返回规则标识
+func (r *rule) check(v reflect.Value) (string, bool) {
+	if r.name == "required" {
+		if isEmpty(v) {
+			return r.key(), false
+		}
+		return "", true
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	ok := true
+	switch r.name {
+	case "min":
+		ok = toFloat(v) >= r.num
+	case "max":
+		ok = toFloat(v) <= r.num
+	case "len":
+		ok = length(v) == r.n
+	case "minlen":
+		ok = length(v) >= r.n
+	case "maxlen":
+		ok = length(v) <= r.n
+	case "regex":
+		ok = r.re.MatchString(v.String())
+	case "enum":
+		if r.enum == nil {
+			e := v.Interface().(protoreflect.Enum)
+			ok = e.Descriptor().Values().ByNumber(e.Number()) != nil
+		} else {
+			ok = r.enum[enumKey(v)]
+		}
+	}
+	if !ok {
+		return r.key(), false
+	}
+	return "", true
+}
+
+func enumKey(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	return strconv.FormatInt(v.Int(), 10)
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+func length(v reflect.Value) int {
+	if v.Kind() == reflect.String {
+		return utf8.RuneCountInString(v.String())
+	}
+	return v.Len()
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case isInt(v.Kind()):
+		return float64(v.Int())
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return v.Float()
+	default:
+		return float64(v.Uint())
+	}
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64 && k != reflect.Uintptr
+}
@@ -0,0 +1,279 @@
+package validatex
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vison888/go-vkit/errorsx"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// 规则标签, 如`validate:"required,maxlen=32"`
+const TagName = "validate"
+
+var (
+	plans sync.Map
+	// 元素类型是否可能包含结构体
+	deepTypes sync.Map
+	// protobuf字段选项中的规则, 由SetProtoExtension设置
+	protoRule func(fd protoreflect.FieldDescriptor) string
+
+	protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+)
+
+type validator interface {
+	Validate() error
+}
+
+type typePlan struct {
+	fields []*fieldPlan
+	err    error
+}
+
+type fieldPlan struct {
+	index int
+	name  string
+	rules []*rule
+	// 字段值中可能包含结构体, 需继续遍历
+	deep bool
+	// 未设置required时零值跳过其他规则
+	required bool
+}
+
+// SetProtoExtension 从protobuf字段选项读取规则, xt为string类型的FieldOptions扩展, 规则格式同validate标签
+//
+//	extend google.protobuf.FieldOptions { string rules = 50001; }
+//	string name = 1 [(rules) = "required,maxlen=32"];
+func SetProtoExtension(xt protoreflect.ExtensionType) {
+	protoRule = func(fd protoreflect.FieldDescriptor) string {
+		opts := fd.Options()
+		if opts == nil || !proto.HasExtension(opts, xt) {
+			return ""
+		}
+		s, _ := proto.GetExtension(opts, xt).(string)
+		return s
+	}
+	plans = sync.Map{}
+}
+
+// Check 先按标签及protobuf选项校验, 通过后调用请求的Validate方法
+// Validate返回NetError或Errno时保留其错误码, 其余错误与原有行为一致, 为业务错误码-1及"param error: "开头的消息
+func Check(v any) error {
+	if err := Struct(v); err != nil {
+		return err
+	}
+	vv, ok := v.(validator)
+	if !ok {
+		return nil
+	}
+	err := vv.Validate()
+	if err == nil {
+		return nil
+	}
+	var nerr *neterrors.NetError
+	if errors.As(err, &nerr) {
+		return nerr
+	}
+	var verr *errorsx.Errno
+	if errors.As(err, &verr) {
+		return verr.ToNetError()
+	}
+	return neterrors.BusinessError(-1, "param error: %s", err.Error())
+}
+
+// Struct 按标签及protobuf选项校验, 失败时返回携带字段详情的参数错误
+func Struct(v any) error {
+	violations, err := Violations(v)
+	if err != nil {
+		return neterrors.InternalServerError("[validatex] %s", err.Error())
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	first := violations[0]
+	return errorsx.PARAM_ERR.
+		WithDetail(fmt.Sprintf("%s %s", first.Field, first.Description)).
+		WithViolations(violations...).
+		ToNetError()
+}
+
+// Violations 返回所有校验失败的字段, 嵌套字段如user.name、items[0].id
+func Violations(v any) ([]*neterrors.FieldViolation, error) {
+	w := &walker{violations: make([]*neterrors.FieldViolation, 0)}
+	w.walk(nil, reflect.ValueOf(v))
+	return w.violations, w.err
+}
+
+type walker struct {
+	violations []*neterrors.FieldViolation
+	err        error
+}
+
+// fieldPath 字段路径, 只在记录错误时拼接
+type fieldPath struct {
+	parent *fieldPath
+	// 字段名, 为空时为元素下标或map键
+	name  string
+	index int
+	key   reflect.Value
+}
+
+func (p *fieldPath) String() string {
+	if p == nil {
+		return ""
+	}
+	parent := p.parent.String()
+	switch {
+	case p.name != "" && parent == "":
+		return p.name
+	case p.name != "":
+		return parent + "." + p.name
+	case p.key.IsValid():
+		return fmt.Sprintf("%s[%v]", parent, p.key)
+	default:
+		return parent + "[" + strconv.Itoa(p.index) + "]"
+	}
+}
+
+func (w *walker) walk(path *fieldPath, rv reflect.Value) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+	case reflect.Slice, reflect.Array:
+		// 如[]byte、[]string无需逐个遍历
+		if !hasStruct(rv.Type().Elem()) {
+			return
+		}
+		for i := 0; i < rv.Len(); i++ {
+			w.walk(&fieldPath{parent: path, index: i}, rv.Index(i))
+		}
+		return
+	case reflect.Map:
+		if !hasStruct(rv.Type().Elem()) {
+			return
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			w.walk(&fieldPath{parent: path, key: iter.Key()}, iter.Value())
+		}
+		return
+	default:
+		return
+	}
+
+	plan := planOf(rv.Type())
+	if plan.err != nil {
+		w.err = plan.err
+		return
+	}
+	for _, fp := range plan.fields {
+		fv := rv.Field(fp.index)
+		field := &fieldPath{parent: path, name: fp.name}
+
+		if fp.required || !isEmpty(fv) {
+			for _, r := range fp.rules {
+				if desc, ok := r.check(fv); !ok {
+					w.violations = append(w.violations, &neterrors.FieldViolation{Field: field.String(), Description: desc})
+					break
+				}
+			}
+		}
+		if fp.deep {
+			w.walk(field, fv)
+		}
+	}
+}
+
+// hasStruct 该类型的值中是否可能包含结构体, 递归类型视为包含
+func hasStruct(t reflect.Type) bool {
+	if v, ok := deepTypes.Load(t); ok {
+		return v.(bool)
+	}
+	deepTypes.Store(t, true)
+	var deep bool
+	switch t.Kind() {
+	case reflect.Struct, reflect.Interface:
+		deep = true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		deep = hasStruct(t.Elem())
+	}
+	deepTypes.Store(t, deep)
+	return deep
+}
+
+func planOf(t reflect.Type) *typePlan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*typePlan)
+	}
+	p := compile(t)
+	plans.Store(t, p)
+	return p
+}
+
+func compile(t reflect.Type) *typePlan {
+	var desc protoreflect.MessageDescriptor
+	if protoRule != nil && reflect.PointerTo(t).Implements(protoMessageType) {
+		desc = reflect.New(t).Interface().(proto.Message).ProtoReflect().Descriptor()
+	}
+
+	p := &typePlan{fields: make([]*fieldPlan, 0)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get(TagName)
+		if desc != nil {
+			if fd := desc.Fields().ByName(protoreflect.Name(protoName(f))); fd != nil {
+				if opt := protoRule(fd); opt != "" {
+					tag = strings.Trim(tag+","+opt, ",")
+				}
+			}
+		}
+
+		rules, err := parseRules(tag, f.Type)
+		if err != nil {
+			p.err = fmt.Errorf("%s.%s %w", t.Name(), f.Name, err)
+			return p
+		}
+		fp := &fieldPlan{index: i, name: fieldName(f), rules: rules, deep: hasStruct(f.Type)}
+		for _, r := range rules {
+			if r.name == "required" {
+				fp.required = true
+			}
+		}
+		p.fields = append(p.fields, fp)
+	}
+	return p
+}
+
+// fieldName 优先使用json名, 与客户端请求一致
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// protoName 取protobuf标签中的字段名, 如`protobuf:"bytes,1,opt,name=user_id,proto3"`
+func protoName(f reflect.StructField) string {
+	for _, part := range strings.Split(f.Tag.Get("protobuf"), ",") {
+		if name, ok := strings.CutPrefix(part, "name="); ok {
+			return name
+		}
+	}
+	return ""
+}
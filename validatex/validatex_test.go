@@ -0,0 +1,154 @@
+package validatex
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vison888/go-vkit/errorsx"
+	"github.com/vison888/go-vkit/errorsx/neterrors"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"len=6,regex=^[0-9]+$"`
+}
+
+type createUserReq struct {
+	Name    string            `json:"name" validate:"required,minlen=2,maxlen=8"`
+	Age     int32             `json:"age" validate:"min=1,max=150"`
+	Role    string            `json:"role" validate:"enum=admin|user"`
+	Email   *string           `json:"email" validate:"regex=^[^@]+@[^@]+$"`
+	Addr    *address          `json:"addr" validate:"required"`
+	Backups []*address        `json:"backups" validate:"maxlen=2"`
+	Tags    map[string]string `json:"tags"`
+}
+
+func violationMap(t *testing.T, v any) map[string]string {
+	vs, err := Violations(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string)
+	for _, v := range vs {
+		m[v.Field] = v.Description
+	}
+	return m
+}
+
+func TestViolations(t *testing.T) {
+	email := "bad"
+	req := &createUserReq{
+		Name:    "张",
+		Age:     200,
+		Role:    "root",
+		Email:   &email,
+		Backups: []*address{{City: "sz", Zip: "518000"}, {Zip: "51800a"}},
+	}
+	m := violationMap(t, req)
+	want := map[string]string{
+		"name":            "minlen=2",
+		"age":             "max=150",
+		"role":            "enum=admin|user",
+		"email":           "regex",
+		"addr":            "required",
+		"backups[1].city": "required",
+		"backups[1].zip":  "regex",
+	}
+	if len(m) != len(want) {
+		t.Fatalf("invalid violations %v", m)
+	}
+	for k, v := range want {
+		if m[k] != v {
+			t.Fatalf("field %s want %s got %s", k, v, m[k])
+		}
+	}
+
+	// 非必填字段为零值时跳过校验
+	req = &createUserReq{Name: "张三", Addr: &address{City: "sz"}}
+	if m := violationMap(t, req); len(m) != 0 {
+		t.Fatalf("should pass %v", m)
+	}
+}
+
+type badTagReq struct {
+	Name string `validate:"min=1"`
+}
+
+type customReq struct {
+	Start int `json:"start" validate:"min=0"`
+	End   int `json:"end"`
+}
+
+func (r *customReq) Validate() error {
+	if r.End < r.Start {
+		return errors.New("end before start")
+	}
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	err := Check(&createUserReq{Name: "张三"})
+	var nerr *neterrors.NetError
+	if !errors.As(err, &nerr) || nerr.Code != errorsx.PARAM_ERR.Code || len(nerr.Violations) != 1 || nerr.Violations[0].Field != "addr" {
+		t.Fatalf("invalid error %v", err)
+	}
+
+	// Validate返回的普通错误保持原有错误码及消息
+	if err := Check(&customReq{Start: 2, End: 1}); !errors.As(err, &nerr) || nerr.Code != -1 || nerr.Msg != "param error: end before start" {
+		t.Fatalf("Validate should be called, err:%v", err)
+	}
+
+	if err := Check(&badTagReq{}); err == nil || !errors.As(err, &nerr) || nerr.Status != 500 {
+		t.Fatalf("invalid rule should fail, err:%v", err)
+	}
+}
+
+func TestProtoRule(t *testing.T) {
+	protoRule = func(fd protoreflect.FieldDescriptor) string {
+		switch fd.Name() {
+		case "service":
+			return "required"
+		case "status":
+			return "enum"
+		}
+		return ""
+	}
+	defer func() {
+		protoRule = nil
+		plans = sync.Map{}
+	}()
+
+	m := violationMap(t, &healthpb.HealthCheckRequest{})
+	if m["service"] != "required" {
+		t.Fatalf("proto rule not applied %v", m)
+	}
+
+	m = violationMap(t, &healthpb.HealthCheckResponse{Status: 100})
+	if m["status"] != "enum" {
+		t.Fatalf("proto enum not checked %v", m)
+	}
+	if m = violationMap(t, &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}); len(m) != 0 {
+		t.Fatalf("unexpected violations %v", m)
+	}
+}
+
+type uploadReq struct {
+	Name    string `json:"name" validate:"required"`
+	Content []byte `json:"content"`
+}
+
+func TestSkipPlainSlice(t *testing.T) {
+	req := &uploadReq{Name: "a", Content: make([]byte, 8*1024*1024)}
+	start := time.Now()
+	if err := Check(req); err != nil {
+		t.Fatal(err)
+	}
+	// []byte不逐个遍历
+	if cost := time.Since(start); cost > time.Millisecond*50 {
+		t.Fatalf("check []byte too slow, cost:%s", cost)
+	}
+}